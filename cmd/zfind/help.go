@@ -20,18 +20,57 @@ Examples:
   # find files modified before 2010 inside a tar
   zfind 'date<"2010" and archive="tar"'
 
+  # find files modified in the last 7 days
+  zfind 'mtime > now() - interval 7 days'
+
+  # find files modified in the last two weeks
+  zfind 'date > "2 weeks ago"'
+
   # find files named foo* and modified today
   zfind 'name like "foo%" and date=today'
 
+  # find files between 10 and 20 KB using arithmetic
+  zfind 'size / 1K between 10 and 20'
+
+  # find files that contain a literal % character
+  zfind 'name like "%\%%"'
+
+  # find jpg files anywhere below a src directory using a glob
+  zfind 'path glob "**/src/**/*.{jpg,jpeg}"'
+
+  # find files whose name is similar to "reprot", best matches first
+  zfind --rank 'name ~ "reprot"'
+
   # find files that contain two dashes using a regex
   zfind 'name rlike "(.*-){2}"'
 
+  # find files with names longer than 100 characters
+  zfind 'length(name) > 100'
+
   # find files that have the extension .jpg or .jpeg
   zfind 'ext in ("jpg","jpeg")'
 
+  # find files that are not inside an archive
+  zfind 'container is null'
+
+  # find files in archives that are older than 2020
+  zfind 'archive and container.date < "2020"'
+
+  # find zip files that contain an executable
+  zfind 'ext = "zip" and exists(entries where name like "%.exe")'
+
+  # find files that anyone can write to or that run as their owner
+  zfind 'type = "file" and (world_writable or setuid)' -l
+
+  # find files below a node_modules directory
+  zfind '"node_modules" in segments'
+
   # find directories named foo and bar
   zfind 'name in ("foo", "bar") and type="dir"'
 
+  # find café.txt even if the name was stored decomposed (e.g. by macOS), ignoring case
+  zfind --normalize nfc -i 'name = "Café.txt"'
+
   # search for all README.md files and show in long listing format
   zfind 'name="README.md"' -l
 
@@ -39,6 +78,13 @@ Examples:
   zfind --csv
   zfind --csv-no-head
 
+  # read a long filter from a file (or from stdin with -f -), it can
+  # contain -- and /* */ comments
+  zfind -f audit.sql /srv/share
+
+  # add computed columns (to the listing or csv)
+  zfind --csv -c 'kind=case when ext in ("jpg", "png") then "image" else "other" end'
+
 The following file properties are available:
 
   name        name of the file
@@ -46,15 +92,36 @@ The following file properties are available:
   size        file size (uncompressed)
   date        modified date in YYYY-MM-DD format
   time        modified time in HH-MM-SS format
+  mtime       modified date and time, compares with "YYYY-MM-DD HH:MM:SS" text
   ext         short file extension (e.g. 'txt')
   ext2        long file extension (two parts, e.g. 'tar.gz')
   type        file|dir|link
-  archive     archive type tar|zip|7z|rar if inside a container
-  container   path of container (if any)
+  archive     archive type tar|zip|7z|rar if inside a container, otherwise NULL
+  container   path of container, otherwise NULL
+  segments    list of the parts of the path, e.g. ["src", "main.go"]
+  exts        list of all file extensions (e.g. ["tar", "gz"])
+  entries     the entries of an archive, see Archive contents
+  mode        type and permissions like ls -l, e.g. -rwxr-xr-x
+  perm        permissions in octal, e.g. 0755 or 4755 with setuid
+  is_executable   true for files that anyone may execute
+  setuid, setgid, sticky   true if the special bit is set
+  world_writable  true if anyone may write to the file
+  (the mode properties are NULL if an archive does not store them, links
+  only have a mode)
+
+Prefix a property to get it from a related file, e.g. container.date
+
+  container.  the archive that contains the file, otherwise NULL
+  target.     the file a link points to, otherwise NULL
+  parent.     the directory that contains the file
 
 Helper properties
 
   today       todays date
+  yesterday   yesterday's date
+  this_week   date of this week's monday (also last_week)
+  this_month  first day of this month (also last_month)
+  this_year   first day of this year (also last_year)
   mo          last monday's date
   tu          last tuesday's date
   we          last wednesday's date
@@ -63,5 +130,44 @@ Helper properties
   sa          last saturday's date
   su          last sunday's date
 
+Functions
+
+  lower(text)               text in lower case
+  upper(text)               text in upper case
+  length(text)              number of characters in text
+  substr(text, start, len)  part of text starting at start (1-based), len is optional
+  replace(text, old, new)   text with all occurrences of old replaced by new
+  concat(a, ...)            arguments joined as text
+  coalesce(a, ...)          first argument that is not NULL
+  abs(number)               absolute value of a number
+  levenshtein(a, b)         number of characters that must be changed to turn a into b
+  similarity(a, b)          1 for equal texts down to 0 for texts that have nothing in common
+  now()                     current date and time
+  iif(cond, a, b)           a if cond is true, otherwise b
+  cardinality(list)         number of elements in a list
+
+Lists
+
+  x IN list, list CONTAINS x      check if the list contains x
+  ANY(list) LIKE "a%"             true if the comparison is true for one element
+  ALL(list) > 1                   true if the comparison is true for all elements
+
+Conditional values
+
+  CASE WHEN cond THEN a [WHEN ...] [ELSE b] END
+  CASE x WHEN v THEN a [WHEN ...] [ELSE b] END
+
+Archive contents
+
+  exists(entries WHERE cond)      true if the archive has an entry matching cond
+  count(entries WHERE cond)       number of entries matching cond
+  count_entries(WHERE cond)       short for count(entries WHERE cond)
+
+Relative dates
+
+  Text like "3 days ago", "2 weeks ago", "-36h", "-2d" or the names of the
+  helper properties (e.g. "last_month") are resolved to a date when they are
+  compared to date or mtime.
+
 For more details go to https://github.com/laktak/zfind
 `
@@ -2,19 +2,79 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/alecthomas/kong"
 	"github.com/fatih/color"
 	"github.com/laktak/zfind/filter"
 	"github.com/laktak/zfind/find"
+	"golang.org/x/text/unicode/norm"
 )
 
 var appVersion = "vdev"
 
-func printFiles(ch chan find.FileInfo, long bool, archSep string, lineSep []byte) {
+var normForms = map[string]norm.Form{"nfc": norm.NFC, "nfd": norm.NFD, "nfkc": norm.NFKC}
+
+// column is a computed column that is added to the listing.
+type column struct {
+	name string
+	expr *filter.FilterExpression
+}
+
+// parseColumns parses column definitions in the form NAME=EXPR.
+func parseColumns(defs []string, opts []filter.Option) ([]column, error) {
+	var columns []column
+	for _, def := range defs {
+		name, expr, ok := strings.Cut(def, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, fmt.Errorf("invalid column \"%s\", expected NAME=EXPR", def)
+		}
+		f, err := filter.CreateFilter(expr, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, filterError("", expr, err))
+		}
+		columns = append(columns, column{name, f})
+	}
+	return columns, nil
+}
+
+// fileError formats an error for a file like the errors of the search, the
+// errors of archive entries are reported for the archive.
+func fileError(file find.FileInfo, err error) string {
+	path := file.Path
+	if file.Container != "" {
+		path = file.Container
+	}
+	return (&find.FindError{Path: path, Err: err}).Error()
+}
+
+// columnValues evaluates the computed columns for a file.
+func columnValues(columns []column, file find.FileInfo) ([]string, error) {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		v, err := c.expr.Eval(file.ContextAt(c.expr.Now()))
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.name, err)
+		}
+		values[i] = v.String()
+	}
+	return values, nil
+}
+
+func printFiles(ch chan find.FileInfo, errChan chan string, long bool, archSep string, lineSep []byte, columns []column) error {
 	for file := range ch {
+		values, err := columnValues(columns, file)
+		if err != nil {
+			errChan <- fileError(file, err)
+			continue
+		}
 		name := ""
 		if file.Container != "" {
 			name = file.Container + archSep
@@ -22,19 +82,27 @@ func printFiles(ch chan find.FileInfo, long bool, archSep string, lineSep []byte
 		name += file.Path
 		if long {
 			size := filter.FormatSize(file.Size)
-			fmt.Fprintf(os.Stdout, "%s %10s %s", file.ModTime.Format("2006-01-02 15:04:05"), size, name)
+			fmt.Fprintf(os.Stdout, "%s %s %10s %s", file.ModeString(), file.ModTime.Format("2006-01-02 15:04:05"), size, name)
 		} else {
 			fmt.Fprint(os.Stdout, name)
 		}
+		for _, v := range values {
+			fmt.Fprint(os.Stdout, "\t"+v)
+		}
 		os.Stdout.Write(lineSep)
 	}
+	return nil
 }
 
-func printCsv(header bool, ch chan find.FileInfo) error {
+func printCsv(header bool, ch chan find.FileInfo, errChan chan string, columns []column) error {
 	writer := csv.NewWriter(os.Stdout)
 
 	if header {
-		if err := writer.Write(find.Fields[:]); err != nil {
+		names := find.Fields[:]
+		for _, c := range columns {
+			names = append(names, c.name)
+		}
+		if err := writer.Write(names); err != nil {
 			return err
 		}
 	}
@@ -46,6 +114,12 @@ func printCsv(header bool, ch chan find.FileInfo) error {
 			value := getter(field)
 			record = append(record, (*value).String())
 		}
+		values, err := columnValues(columns, file)
+		if err != nil {
+			errChan <- fileError(file, err)
+			continue
+		}
+		record = append(record, values...)
 		if err := writer.Write(record); err != nil {
 			return err
 		}
@@ -60,6 +134,96 @@ func printCsv(header bool, ch chan find.FileInfo) error {
 	return nil
 }
 
+// rankFiles collects all files and returns them ordered by the score of the
+// filter's fuzzy matches, best first. Errors are sent to errChan like the
+// errors of the search.
+func rankFiles(ch chan find.FileInfo, f *filter.FilterExpression, errChan chan string) chan find.FileInfo {
+	type ranked struct {
+		file  find.FileInfo
+		score float64
+	}
+	out := make(chan find.FileInfo)
+	go func() {
+		var files []ranked
+		for file := range ch {
+			_, score, err := f.Score(file.ContextAt(f.Now()))
+			if err != nil {
+				errChan <- fileError(file, err)
+			}
+			files = append(files, ranked{file, score})
+		}
+		sort.SliceStable(files, func(i, j int) bool { return files[i].score > files[j].score })
+		for _, r := range files {
+			out <- r.file
+		}
+		close(out)
+	}()
+	return out
+}
+
+// filterError adds the filter line and a marker under the offending part to
+// errors that carry a position. If the filter was read from a file, its name
+// is added in front of the position.
+func filterError(file, where string, err error) error {
+	var perr *filter.PatternError
+	var serr *filter.SyntaxError
+	var cerr *filter.CompileError
+	prefix := ""
+	if file != "" {
+		prefix = file + ":"
+	}
+	switch {
+	case errors.As(err, &perr):
+		return fmt.Errorf("%s%w\n%s", prefix, err, markPos(where, perr.Pos, perr.End))
+	case errors.As(err, &serr):
+		return fmt.Errorf("%s%w\n%s", prefix, err, markPos(where, serr.Pos, serr.End))
+	case errors.As(err, &cerr):
+		return fmt.Errorf("%s%w\n%s", prefix, err, markPos(where, cerr.Pos, cerr.End))
+	case err != nil && file != "":
+		return fmt.Errorf("%s %w", prefix, err)
+	}
+	return err
+}
+
+// readFilter reads the filter from a file, or from stdin if file is "-". It
+// returns the filter and the name to use in error messages.
+func readFilter(file string) (string, string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+		file = "stdin"
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	// so that a missing end is reported on the last line
+	return strings.TrimRight(string(data), " \t\r\n"), file, err
+}
+
+// markPos returns the line of src that contains pos followed by a line with
+// carets from pos to end.
+func markPos(src string, pos, end filter.Position) string {
+	if pos.Offset < 0 || pos.Offset > len(src) {
+		return ""
+	}
+	start := strings.LastIndexByte(src[:pos.Offset], '\n') + 1
+	line := src[start:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, src[start:pos.Offset])
+	n := 1
+	if end.Offset > pos.Offset && end.Offset <= start+len(line) {
+		n = utf8.RuneCountInString(src[pos.Offset:end.Offset])
+	}
+	return line + "\n" + indent + strings.Repeat("^", n)
+}
+
 func main() {
 	var cli struct {
 		FilterHelp       bool     `short:"H" help:"Show where-filter help."`
@@ -70,7 +234,12 @@ func main() {
 		FollowSymlinks   bool     `short:"L" help:"Follow symbolic links."`
 		NoArchive        bool     `short:"n" help:"Disables archive support."`
 		Print0           bool     `name:"print0" short:"0" help:"Use a null character instead of the newline character, to be used with the -0 option of xargs."`
+		Rank             bool     `short:"r" help:"Sort the results by the score of fuzzy matches (~ or similarity()), best first."`
+		IgnoreCase       bool     `short:"i" help:"Ignore case when comparing text with =, IN and LIKE."`
+		Normalize        string   `help:"Normalize text to a Unicode form before comparing it (${enum})." enum:"none,nfc,nfd,nfkc" default:"none"`
+		Column           []string `short:"c" sep:"none" placeholder:"NAME=EXPR" help:"Add a column that is computed with the filter syntax, e.g. 'kind=case when ext=\"go\" then \"code\" end'. Can be repeated."`
 		Version          bool     `short:"V" help:"Show version."`
+		FilterFile       string   `short:"f" placeholder:"FILE" help:"Read the filter from a file ('-' for stdin), the where argument is then used as a path."`
 		Where            string   `arg:"" name:"where" optional:"" help:"The filter using SQL-where syntax (see -H). Use '-' to skip when providing a path."`
 		Paths            []string `arg:"" name:"path" optional:"" help:"Paths to search."`
 	}
@@ -90,7 +259,15 @@ func main() {
 		os.Exit(0)
 	}
 
-	if cli.Where == "" || cli.Where == "-" {
+	whereFile := ""
+	if cli.FilterFile != "" {
+		if cli.Where != "" && cli.Where != "-" {
+			cli.Paths = append([]string{cli.Where}, cli.Paths...)
+		}
+		var err error
+		cli.Where, whereFile, err = readFilter(cli.FilterFile)
+		arg.FatalIfErrorf(err)
+	} else if cli.Where == "" || cli.Where == "-" {
 		cli.Where = "1"
 	}
 
@@ -103,7 +280,18 @@ func main() {
 		cli.Paths = []string{"."}
 	}
 
-	filter, err := filter.CreateFilter(cli.Where)
+	// the filter and the columns share the time for relative dates
+	opts := []filter.Option{filter.WithSchema(find.Schema), filter.WithNow(time.Now())}
+	if cli.IgnoreCase {
+		opts = append(opts, filter.WithCaseFolding())
+	}
+	if form, ok := normForms[cli.Normalize]; ok {
+		opts = append(opts, filter.WithNormalization(form))
+	}
+
+	filter, err := filter.CreateFilter(cli.Where, opts...)
+	arg.FatalIfErrorf(filterError(whereFile, cli.Where, err))
+	columns, err := parseColumns(cli.Column, opts)
 	arg.FatalIfErrorf(err)
 
 	done := make(chan bool)
@@ -121,18 +309,23 @@ func main() {
 				NoArchive:      cli.NoArchive})
 		}
 		close(ch)
-		close(errChan)
 	}()
 
 	// print results
+	results := ch
+	if cli.Rank {
+		results = rankFiles(ch, filter, errChan)
+	}
 	go func() {
 		if cli.Csv {
-			arg.FatalIfErrorf(printCsv(true, ch))
+			arg.FatalIfErrorf(printCsv(true, results, errChan, columns))
 		} else if cli.CsvNoHead {
-			arg.FatalIfErrorf(printCsv(false, ch))
+			arg.FatalIfErrorf(printCsv(false, results, errChan, columns))
 		} else {
-			printFiles(ch, cli.Long, cli.ArchiveSeparator, lineSep)
+			arg.FatalIfErrorf(printFiles(results, errChan, cli.Long, cli.ArchiveSeparator, lineSep, columns))
 		}
+		// the search and the ranking are done before the results are closed
+		close(errChan)
 		done <- true
 	}()
 
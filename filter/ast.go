@@ -0,0 +1,644 @@
+package filter
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Node is an element of the syntax tree of a filter. String returns the
+// canonical form of the node, which can be parsed again.
+type Node interface {
+	// Pos returns the position of the first character of the node in the
+	// filter string.
+	Pos() Position
+	// End returns the position after the last character of the node.
+	End() Position
+	String() string
+}
+
+type span struct {
+	pos, end Position
+}
+
+func (s span) Pos() Position { return s.pos }
+
+func (s span) End() Position { return s.end }
+
+func (s *span) spanRef() *span { return s }
+
+// Logical combines its operands with "AND" or "OR".
+type Logical struct {
+	span
+	Op       string
+	Operands []Node
+}
+
+// Not negates its operand.
+type Not struct {
+	span
+	Operand Node
+}
+
+// Compare compares two values, Op is one of "=", "!=", "<", "<=", ">" or ">=".
+type Compare struct {
+	span
+	Op          string
+	Left, Right Node
+}
+
+// Between checks if Operand is in the range from Low to High (inclusive).
+type Between struct {
+	span
+	Not       bool
+	Operand   Node
+	Low, High Node
+}
+
+// In checks if Operand is equal to one of the values in List. A value that
+// is a list is searched for Operand, e.g. in "x IN segments".
+type In struct {
+	span
+	Not     bool
+	Operand Node
+	List    []Node
+}
+
+// Contains checks if the list Operand contains Value or, for text, if Value
+// is a part of Operand.
+type Contains struct {
+	span
+	Not     bool
+	Operand Node
+	Value   Node
+}
+
+// Like matches Operand against Pattern, Op is one of "LIKE", "ILIKE",
+// "RLIKE", "GLOB" or "IGLOB". Escape is the escape character of a LIKE or
+// ILIKE pattern, nil for the default backslash.
+type Like struct {
+	span
+	Op      string
+	Not     bool
+	Operand Node
+	Pattern Node
+	Escape  Node
+}
+
+// IsNull checks if Operand is NULL.
+type IsNull struct {
+	span
+	Not     bool
+	Operand Node
+}
+
+// Arith is an arithmetic operation, Op is one of "+", "-", "*", "/" or "%".
+type Arith struct {
+	span
+	Op          string
+	Left, Right Node
+}
+
+// Unary is a sign ("-" or "+") applied to Operand.
+type Unary struct {
+	span
+	Op      string
+	Operand Node
+}
+
+// Case returns the Result of the first When whose Cond is true, or Else if
+// there is none. If Operand is set, each Cond is a value that is compared to
+// Operand instead. Else and Operand may be nil.
+type Case struct {
+	span
+	Operand Node
+	Whens   []When
+	Else    Node
+}
+
+// When is a branch of a Case.
+type When struct {
+	Cond, Result Node
+}
+
+// List is a list literal like [1, 2, 3].
+type List struct {
+	span
+	Items []Node
+}
+
+// Call is a function call. The quantifiers ANY(list) and ALL(list) are
+// written as calls and apply the predicate they are an operand of to each
+// element of the list.
+type Call struct {
+	span
+	Name string
+	Args []Node
+}
+
+// Subquery tests the rows of the variable Source (e.g. the entries of an
+// archive) with the condition Where. Func is "exists", which is true if one
+// of the rows matches, or "count", the number of matching rows.
+type Subquery struct {
+	span
+	Func   string
+	Source string
+	Where  Node
+}
+
+// Ident refers to a variable.
+type Ident struct {
+	span
+	Name string
+}
+
+// Param is a placeholder for a value that is bound later, see
+// FilterExpression.Bind. It is written as ":name" or as "?", which is
+// numbered from 1 in the order of appearance (Index).
+type Param struct {
+	span
+	Name  string // empty for "?"
+	Index int    // only set for "?"
+}
+
+// Key returns the name that is used to bind a value to the parameter, the
+// Index for "?".
+func (x *Param) Key() string {
+	if x.Name == "" {
+		return strconv.Itoa(x.Index)
+	}
+	return x.Name
+}
+
+// Literal is a constant value.
+type Literal struct {
+	span
+	Value *Value
+}
+
+// Walk traverses the syntax tree in depth-first order. It calls fn for n and,
+// if fn returns true, walks the children of n.
+func Walk(n Node, fn func(Node) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+	for _, c := range children(n) {
+		Walk(c, fn)
+	}
+}
+
+func children(n Node) []Node {
+	switch x := n.(type) {
+	case *Logical:
+		return x.Operands
+	case *Not:
+		return []Node{x.Operand}
+	case *Compare:
+		return []Node{x.Left, x.Right}
+	case *Between:
+		return []Node{x.Operand, x.Low, x.High}
+	case *In:
+		return append([]Node{x.Operand}, x.List...)
+	case *Contains:
+		return []Node{x.Operand, x.Value}
+	case *Like:
+		if x.Escape != nil {
+			return []Node{x.Operand, x.Pattern, x.Escape}
+		}
+		return []Node{x.Operand, x.Pattern}
+	case *IsNull:
+		return []Node{x.Operand}
+	case *Arith:
+		return []Node{x.Left, x.Right}
+	case *Unary:
+		return []Node{x.Operand}
+	case *Case:
+		var nodes []Node
+		if x.Operand != nil {
+			nodes = append(nodes, x.Operand)
+		}
+		for _, w := range x.Whens {
+			nodes = append(nodes, w.Cond, w.Result)
+		}
+		if x.Else != nil {
+			nodes = append(nodes, x.Else)
+		}
+		return nodes
+	case *List:
+		return x.Items
+	case *Call:
+		return x.Args
+	case *Subquery:
+		return []Node{x.Where}
+	}
+	return nil
+}
+
+// Fields returns the names of the variables that are referenced by n, in the
+// order of their first appearance.
+func Fields(n Node) []string {
+	var names []string
+	seen := map[string]bool{}
+	Walk(n, func(n Node) bool {
+		name := ""
+		switch x := n.(type) {
+		case *Ident:
+			name = x.Name
+		case *Subquery:
+			name = x.Source
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		// the condition of a subquery refers to the fields of the rows
+		_, ok := n.(*Subquery)
+		return !ok
+	})
+	return names
+}
+
+// Params returns the keys of the parameters that are used by n, in the order
+// of their first appearance.
+func Params(n Node) []string {
+	var keys []string
+	seen := map[string]bool{}
+	Walk(n, func(n Node) bool {
+		if x, ok := n.(*Param); ok && !seen[x.Key()] {
+			seen[x.Key()] = true
+			keys = append(keys, x.Key())
+		}
+		return true
+	})
+	return keys
+}
+
+// Parse parses a filter string into a syntax tree without compiling it.
+func Parse(filter string) (Node, error) {
+	expr, err := parser.ParseString("", filter)
+	if err != nil {
+		return nil, syntaxError(filter, err)
+	}
+	n := expr.ast()
+	trimSpans(filter, n)
+	index := 0
+	Walk(n, func(n Node) bool {
+		if x, ok := n.(*Param); ok && x.Name == "" {
+			index++
+			x.Index = index
+		}
+		return true
+	})
+	return n, nil
+}
+
+// trimSpans moves the end of the nodes to the end of their last token, the
+// parser ends them at the start of the next token, after whitespace and
+// comments.
+func trimSpans(filter string, n Node) {
+	toks, err := lexer.ConsumeAll(mustLex(filter))
+	if err != nil {
+		return
+	}
+	Walk(n, func(n Node) bool {
+		s := n.(interface{ spanRef() *span }).spanRef()
+		// the last token that starts before the end
+		i := sort.Search(len(toks), func(i int) bool { return toks[i].Pos.Offset >= s.end.Offset }) - 1
+		if i >= 0 && !toks[i].EOF() {
+			if end := toks[i].Pos.Offset + len(toks[i].Value); end < s.end.Offset {
+				s.end = offsetPosition(filter, end)
+			}
+		}
+		return true
+	})
+}
+
+// offsetPosition returns the position of the byte offset in filter.
+func offsetPosition(filter string, offset int) Position {
+	line := filter[:offset]
+	start := strings.LastIndexByte(line, '\n') + 1
+	return Position{Offset: offset, Line: strings.Count(line, "\n") + 1, Column: utf8.RuneCountInString(line[start:]) + 1}
+}
+
+// rewrite returns a copy of the syntax tree where each node is replaced by
+// the result of fn. fn is called after the children of a node were rewritten.
+func rewrite(n Node, fn func(Node) Node) Node {
+	if n == nil {
+		return nil
+	}
+	all := func(nodes []Node) []Node {
+		r := make([]Node, len(nodes))
+		for i, c := range nodes {
+			r[i] = rewrite(c, fn)
+		}
+		return r
+	}
+	switch x := n.(type) {
+	case *Logical:
+		c := *x
+		c.Operands = all(x.Operands)
+		n = &c
+	case *Not:
+		c := *x
+		c.Operand = rewrite(x.Operand, fn)
+		n = &c
+	case *Compare:
+		c := *x
+		c.Left, c.Right = rewrite(x.Left, fn), rewrite(x.Right, fn)
+		n = &c
+	case *Between:
+		c := *x
+		c.Operand, c.Low, c.High = rewrite(x.Operand, fn), rewrite(x.Low, fn), rewrite(x.High, fn)
+		n = &c
+	case *In:
+		c := *x
+		c.Operand, c.List = rewrite(x.Operand, fn), all(x.List)
+		n = &c
+	case *Contains:
+		c := *x
+		c.Operand, c.Value = rewrite(x.Operand, fn), rewrite(x.Value, fn)
+		n = &c
+	case *Like:
+		c := *x
+		c.Operand, c.Pattern, c.Escape = rewrite(x.Operand, fn), rewrite(x.Pattern, fn), rewrite(x.Escape, fn)
+		n = &c
+	case *IsNull:
+		c := *x
+		c.Operand = rewrite(x.Operand, fn)
+		n = &c
+	case *Arith:
+		c := *x
+		c.Left, c.Right = rewrite(x.Left, fn), rewrite(x.Right, fn)
+		n = &c
+	case *Unary:
+		c := *x
+		c.Operand = rewrite(x.Operand, fn)
+		n = &c
+	case *Case:
+		c := *x
+		c.Operand, c.Else = rewrite(x.Operand, fn), rewrite(x.Else, fn)
+		c.Whens = make([]When, len(x.Whens))
+		for i, w := range x.Whens {
+			c.Whens[i] = When{Cond: rewrite(w.Cond, fn), Result: rewrite(w.Result, fn)}
+		}
+		n = &c
+	case *List:
+		c := *x
+		c.Items = all(x.Items)
+		n = &c
+	case *Call:
+		c := *x
+		c.Args = all(x.Args)
+		n = &c
+	case *Subquery:
+		c := *x
+		c.Where = rewrite(x.Where, fn)
+		n = &c
+	}
+	return fn(n)
+}
+
+// operator precedence, used to decide where String needs parentheses
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precPredicate
+	precSum
+	precProduct
+	precUnary
+	precPrimary
+)
+
+func precedence(n Node) int {
+	switch x := n.(type) {
+	case *Logical:
+		if x.Op == "OR" {
+			return precOr
+		}
+		return precAnd
+	case *Not:
+		return precNot
+	case *Compare, *Between, *In, *Contains, *Like, *IsNull:
+		return precPredicate
+	case *Arith:
+		if x.Op == "+" || x.Op == "-" {
+			return precSum
+		}
+		return precProduct
+	case *Unary:
+		return precUnary
+	}
+	return precPrimary
+}
+
+// format writes n and adds parentheses if its precedence is lower than prec.
+func format(sb *strings.Builder, n Node, prec int) {
+	if n == nil {
+		sb.WriteString("NULL")
+		return
+	}
+	if precedence(n) < prec {
+		sb.WriteString("(")
+		defer sb.WriteString(")")
+	}
+	switch x := n.(type) {
+	case *Logical:
+		p := precedence(x)
+		for i, o := range x.Operands {
+			if i > 0 {
+				sb.WriteString(" " + x.Op + " ")
+			}
+			format(sb, o, p+1)
+		}
+	case *Not:
+		sb.WriteString("NOT ")
+		format(sb, x.Operand, precNot)
+	case *Compare:
+		format(sb, x.Left, precSum)
+		sb.WriteString(" " + x.Op + " ")
+		format(sb, x.Right, precSum)
+	case *Between:
+		format(sb, x.Operand, precSum)
+		sb.WriteString(notKeyword(x.Not) + " BETWEEN ")
+		format(sb, x.Low, precSum)
+		sb.WriteString(" AND ")
+		format(sb, x.High, precSum)
+	case *In:
+		format(sb, x.Operand, precSum)
+		sb.WriteString(notKeyword(x.Not) + " IN (")
+		formatList(sb, x.List, precSum)
+		sb.WriteString(")")
+	case *Contains:
+		format(sb, x.Operand, precSum)
+		sb.WriteString(notKeyword(x.Not) + " CONTAINS ")
+		format(sb, x.Value, precSum)
+	case *Like:
+		format(sb, x.Operand, precSum)
+		sb.WriteString(notKeyword(x.Not) + " " + x.Op + " ")
+		format(sb, x.Pattern, precSum)
+		if x.Escape != nil {
+			sb.WriteString(" ESCAPE ")
+			format(sb, x.Escape, precSum)
+		}
+	case *IsNull:
+		format(sb, x.Operand, precSum)
+		sb.WriteString(" IS" + notKeyword(x.Not) + " NULL")
+	case *Arith:
+		p := precedence(x)
+		format(sb, x.Left, p)
+		sb.WriteString(" " + x.Op + " ")
+		format(sb, x.Right, p+1)
+	case *Unary:
+		var operand strings.Builder
+		format(&operand, x.Operand, precUnary)
+		sb.WriteString(x.Op)
+		// "--" would start a comment
+		if x.Op == "-" && strings.HasPrefix(operand.String(), "-") {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(operand.String())
+	case *Case:
+		sb.WriteString("CASE")
+		if x.Operand != nil {
+			sb.WriteString(" ")
+			format(sb, x.Operand, precSum)
+		}
+		for _, w := range x.Whens {
+			sb.WriteString(" WHEN ")
+			format(sb, w.Cond, 0)
+			sb.WriteString(" THEN ")
+			format(sb, w.Result, 0)
+		}
+		if x.Else != nil {
+			sb.WriteString(" ELSE ")
+			format(sb, x.Else, 0)
+		}
+		sb.WriteString(" END")
+	case *List:
+		sb.WriteString("[")
+		formatList(sb, x.Items, 0)
+		sb.WriteString("]")
+	case *Call:
+		sb.WriteString(strings.ToLower(x.Name) + "(")
+		formatList(sb, x.Args, 0)
+		sb.WriteString(")")
+	case *Subquery:
+		sb.WriteString(x.Func + "(" + x.Source + " WHERE ")
+		format(sb, x.Where, 0)
+		sb.WriteString(")")
+	case *Ident:
+		sb.WriteString(x.Name)
+	case *Param:
+		if x.Name == "" {
+			sb.WriteString("?")
+		} else {
+			sb.WriteString(":" + x.Name)
+		}
+	case *Literal:
+		sb.WriteString(formatValue(x.Value))
+	}
+}
+
+func formatList(sb *strings.Builder, list []Node, prec int) {
+	for i, n := range list {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		format(sb, n, prec)
+	}
+}
+
+func notKeyword(b bool) string {
+	if b {
+		return " NOT"
+	}
+	return ""
+}
+
+func nodeString(n Node) string {
+	var sb strings.Builder
+	format(&sb, n, 0)
+	return sb.String()
+}
+
+// quoteText quotes s so that the lexer reads it as a single Text token, which
+// cannot contain a double quote.
+func quoteText(s string) string {
+	q := strconv.Quote(s)
+	var sb strings.Builder
+	for i := 0; i < len(q); i++ {
+		if q[i] == '\\' {
+			i++
+			if q[i] == '"' {
+				sb.WriteString(`\x22`)
+			} else {
+				sb.WriteByte('\\')
+				sb.WriteByte(q[i])
+			}
+			continue
+		}
+		sb.WriteByte(q[i])
+	}
+	return sb.String()
+}
+
+// formatValue returns v as a literal in the filter language.
+func formatValue(v *Value) string {
+	switch {
+	case v.IsNull():
+		return "NULL"
+	case v.Number != nil:
+		return strconv.FormatInt(*v.Number, 10)
+	case v.Float != nil:
+		s := strconv.FormatFloat(*v.Float, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case v.Text != nil:
+		return quoteText(*v.Text)
+	case v.Boolean != nil:
+		return strings.ToUpper(strconv.FormatBool(*v.Boolean))
+	case v.Time != nil:
+		return quoteText(v.Time.Format(timeFormat))
+	case v.List != nil:
+		items := make([]string, len(v.List))
+		for i, item := range v.List {
+			items[i] = formatValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		d, sign := *v.Interval, ""
+		if d < 0 {
+			d, sign = -d, "-"
+		}
+		for _, unit := range []string{"week", "day", "hour", "minute"} {
+			if d%intervalUnits[unit] == 0 {
+				return sign + "INTERVAL " + strconv.FormatInt(int64(d/intervalUnits[unit]), 10) + " " + unit
+			}
+		}
+		return sign + "INTERVAL " + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + " second"
+	}
+}
+
+func (x *Logical) String() string  { return nodeString(x) }
+func (x *Not) String() string      { return nodeString(x) }
+func (x *Compare) String() string  { return nodeString(x) }
+func (x *Between) String() string  { return nodeString(x) }
+func (x *In) String() string       { return nodeString(x) }
+func (x *Contains) String() string { return nodeString(x) }
+func (x *Like) String() string     { return nodeString(x) }
+func (x *IsNull) String() string   { return nodeString(x) }
+func (x *Arith) String() string    { return nodeString(x) }
+func (x *Unary) String() string    { return nodeString(x) }
+func (x *Case) String() string     { return nodeString(x) }
+func (x *List) String() string     { return nodeString(x) }
+func (x *Call) String() string     { return nodeString(x) }
+func (x *Subquery) String() string { return nodeString(x) }
+func (x *Ident) String() string    { return nodeString(x) }
+func (x *Param) String() string    { return nodeString(x) }
+func (x *Literal) String() string  { return nodeString(x) }
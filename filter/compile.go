@@ -0,0 +1,909 @@
+package filter
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// evalFunc evaluates a compiled part of a filter expression.
+type evalFunc func(ctx context) (*value, error)
+
+// node is a compiled part of a filter expression.
+type node struct {
+	eval evalFunc
+	// kind of the value that eval returns, KindAny if it is only known during
+	// evaluation
+	kind Kind
+	// estimated cost of eval, AND and OR evaluate cheaper operands first
+	cost int
+	// static is set if eval does not depend on the variables and always
+	// returns the same value
+	static bool
+}
+
+const (
+	costSymbol = 1
+	costOp     = 1
+	costCall   = 5
+	costLike   = 10
+	costRegex  = 20
+	costFuzzy  = 20
+	// a subquery may have to read the rows first, e.g. list an archive
+	costSubquery = 100
+)
+
+// compiler holds the options that apply to the whole filter.
+type compiler struct {
+	schema Schema
+	// functions that prepare text before it is compared, nil to keep it:
+	// text for =, !=, IN and LIKE, norm for GLOB, fold for ILIKE and IGLOB
+	text, norm, fold func(string) string
+}
+
+// patternCacheSize is the number of compiled patterns that are kept for each
+// LIKE, RLIKE or GLOB whose pattern depends on the variables.
+const patternCacheSize = 256
+
+func (c *compiler) compileAll(items []Node) ([]*node, error) {
+	nodes := make([]*node, len(items))
+	for i, item := range items {
+		n, err := c.compile(item)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+	return nodes, nil
+}
+
+func allStatic(nodes ...*node) bool {
+	for _, n := range nodes {
+		if !n.static {
+			return false
+		}
+	}
+	return true
+}
+
+func totalCost(nodes ...*node) int {
+	c := 0
+	for _, n := range nodes {
+		c += n.cost
+	}
+	return c
+}
+
+// sortByCost orders the operands of AND and OR so that cheap checks are done
+// before expensive ones (like regular expressions).
+func sortByCost(nodes []*node) {
+	slices.SortStableFunc(nodes, func(a, b *node) int { return cmp.Compare(a.cost, b.cost) })
+}
+
+// compile converts the syntax tree to closures that evaluate it. Errors are
+// reported at the innermost part of the filter that caused them.
+func (c *compiler) compile(n Node) (*node, error) {
+	r, err := c.compileNode(n)
+	if err == nil {
+		return r, nil
+	}
+	var cerr *CompileError
+	var perr *PatternError
+	if errors.As(err, &cerr) || errors.As(err, &perr) || n.Pos().Line == 0 {
+		return nil, err
+	}
+	return nil, &CompileError{Pos: n.Pos(), End: n.End(), Err: err}
+}
+
+func (c *compiler) compileNode(n Node) (*node, error) {
+	if q, replace := quantifier(n); q != nil {
+		return c.compileQuantified(n, q, replace)
+	}
+	switch x := n.(type) {
+	case *Logical:
+		return c.compileLogical(x)
+	case *Not:
+		r, err := c.compile(x.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return not(r), nil
+	case *Compare:
+		return c.compileCompare(x)
+	case *Between:
+		return c.compileBetween(x)
+	case *In:
+		return c.compileIn(x)
+	case *Contains:
+		return c.compileContains(x)
+	case *Like:
+		return c.compileLike(x)
+	case *IsNull:
+		lhs, err := c.compile(x.Operand)
+		if err != nil {
+			return nil, err
+		}
+		negate := x.Not
+		return &node{kind: KindBool, cost: lhs.cost + costOp, static: lhs.static, eval: func(ctx context) (*value, error) {
+			v1, err := lhs.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return boolValue(v1.isNull() != negate), nil
+		}}, nil
+	case *Arith:
+		return c.compileArith(x)
+	case *Unary:
+		return c.compileUnary(x)
+	case *Case:
+		return c.compileCase(x)
+	case *List:
+		return c.compileList(x)
+	case *Subquery:
+		return c.compileSubquery(x)
+	case *Call:
+		return c.compileCall(x)
+	case *element:
+		index := x.index
+		return &node{kind: KindAny, cost: costSymbol, eval: func(ctx context) (*value, error) { return ctx.elems[index], nil }}, nil
+	case *Ident:
+		return c.compileIdent(x)
+	case *Param:
+		err := fmt.Errorf("parameter \"%s\" is not bound", x.Key())
+		return &node{kind: KindAny, eval: func(ctx context) (*value, error) { return nil, err }}, nil
+	case *Literal:
+		v := nullValue()
+		if x.Value != nil {
+			v, _ = x.Value.tovalue("")
+		}
+		return &node{kind: v.kind(), static: true, eval: func(ctx context) (*value, error) { return v, nil }}, nil
+	}
+	return nil, fmt.Errorf("unsupported node %T", n)
+}
+
+// not negates the result of n, with three-valued logic NULL stays NULL.
+func not(n *node) *node {
+	return &node{kind: KindBool, cost: n.cost, static: n.static, eval: func(ctx context) (*value, error) {
+		return ctx.not(n.eval(ctx))
+	}}
+}
+
+// withNot applies NOT to the predicate n if it is negated.
+func withNot(negate bool, n *node) *node {
+	if negate {
+		return not(n)
+	}
+	return n
+}
+
+func (c *compiler) compileLogical(x *Logical) (*node, error) {
+	nodes, err := c.compileAll(x.Operands)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	sortByCost(nodes)
+	// OR is decided by the first true operand, AND by the first false one
+	decisive := x.Op == "OR"
+	return &node{kind: KindBool, cost: totalCost(nodes...), static: allStatic(nodes...), eval: func(ctx context) (*value, error) {
+		unknown, decided := false, false
+		for _, o := range nodes {
+			v, err := o.eval(ctx)
+			switch {
+			case decided:
+				// only evaluated to collect the scores of fuzzy matches
+			case err != nil:
+				return nil, err
+			case ctx.tvl && v.isNull():
+				unknown = true
+			case v.Bool() == decisive:
+				if ctx.score == nil {
+					return boolValue(decisive), nil
+				}
+				decided = true
+			}
+		}
+		if decided {
+			return boolValue(decisive), nil
+		} else if unknown {
+			return nullValue(), nil
+		}
+		return boolValue(!decisive), nil
+	}}, nil
+}
+
+func (c *compiler) compileCompare(x *Compare) (*node, error) {
+	lhs, err := c.compile(x.Left)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := c.compile(x.Right)
+	if err != nil {
+		return nil, err
+	}
+	op := x.Op
+	if op == "~" {
+		return compileFuzzy(lhs, rhs)
+	}
+	if !comparableKinds(lhs.kind, rhs.kind) {
+		return nil, kindError(op, lhs.kind, rhs.kind)
+	}
+	var text func(string) string
+	if op == "=" || op == "!=" {
+		text = c.text
+	}
+	return &node{kind: KindBool, cost: totalCost(lhs, rhs) + costOp, static: allStatic(lhs, rhs), eval: func(ctx context) (*value, error) {
+		v1, err := lhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v2, err := rhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return compareValues(ctx, op, transformText(text, v1), transformText(text, v2))
+	}}, nil
+}
+
+// compileFuzzy compiles the ~ operator, each match records its score.
+func compileFuzzy(lhs, rhs *node) (*node, error) {
+	for _, k := range []Kind{lhs.kind, rhs.kind} {
+		if k != KindText && k != KindAny && k != KindNull {
+			return nil, kindError("~", lhs.kind, rhs.kind)
+		}
+	}
+	return &node{kind: KindBool, cost: totalCost(lhs, rhs) + costFuzzy, static: allStatic(lhs, rhs), eval: func(ctx context) (*value, error) {
+		v1, err := lhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v2, err := rhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if v1.isNull() || v2.isNull() {
+			return nullValue(), nil
+		}
+		ok, score := fuzzyMatch(v1.String(), v2.String())
+		if ok {
+			ctx.addScore(score)
+		}
+		return boolValue(ok), nil
+	}}, nil
+}
+
+func (c *compiler) compileBetween(x *Between) (*node, error) {
+	nodes, err := c.compileAll([]Node{x.Operand, x.Low, x.High})
+	if err != nil {
+		return nil, err
+	}
+	lhs, start, end := nodes[0], nodes[1], nodes[2]
+	for _, n := range []*node{start, end} {
+		if !comparableKinds(lhs.kind, n.kind) {
+			return nil, kindError("BETWEEN", lhs.kind, n.kind)
+		}
+	}
+	return withNot(x.Not, &node{kind: KindBool, cost: totalCost(lhs, start, end) + costOp, static: allStatic(lhs, start, end), eval: func(ctx context) (*value, error) {
+		v1, err := lhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v2, err := start.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v3, err := end.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return betweenValues(ctx, v1, v2, v3)
+	}}), nil
+}
+
+func (c *compiler) compileIn(x *In) (*node, error) {
+	lhs, err := c.compile(x.Operand)
+	if err != nil {
+		return nil, err
+	}
+	list, err := c.compileAll(x.List)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range list {
+		if n.kind != KindList && !comparableKinds(lhs.kind, n.kind) {
+			return nil, kindError("IN", lhs.kind, n.kind)
+		}
+	}
+	return withNot(x.Not, &node{kind: KindBool, cost: totalCost(lhs) + totalCost(list...) + costOp, static: lhs.static && allStatic(list...), eval: func(ctx context) (*value, error) {
+		v1, err := lhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if v1.isNull() {
+			return v1, nil
+		}
+		hasNull := false
+		for _, o := range list {
+			v2, err := o.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			items := []*value{v2}
+			if v2.List != nil {
+				items = v2.List
+			}
+			if r, err := c.contains(ctx, items, v1); err != nil {
+				return nil, err
+			} else if r.isNull() {
+				hasNull = true
+			} else if r.Bool() {
+				return r, nil
+			}
+		}
+		if hasNull {
+			return nullValue(), nil
+		}
+		return boolValue(false), nil
+	}}), nil
+}
+
+// contains checks if v is equal to one of the items. Like SQL's IN, the
+// result is NULL if v was not found and one of the items is NULL.
+func (c *compiler) contains(ctx context, items []*value, v *value) (*value, error) {
+	v = transformText(c.text, v)
+	hasNull := false
+	for _, item := range items {
+		if item.isNull() {
+			hasNull = true
+		} else if eq, err := equalValues(ctx, v, transformText(c.text, item)); err != nil {
+			return nil, err
+		} else if eq {
+			return boolValue(true), nil
+		}
+	}
+	if hasNull {
+		return nullValue(), nil
+	}
+	return boolValue(false), nil
+}
+
+func (c *compiler) compileContains(x *Contains) (*node, error) {
+	lhs, err := c.compile(x.Operand)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := c.compile(x.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch lhs.kind {
+	case KindList, KindAny, KindNull:
+	case KindText:
+		if !comparableKinds(lhs.kind, rhs.kind) {
+			return nil, kindError("CONTAINS", lhs.kind, rhs.kind)
+		}
+	default:
+		return nil, kindError("CONTAINS", lhs.kind, rhs.kind)
+	}
+	return withNot(x.Not, &node{kind: KindBool, cost: totalCost(lhs, rhs) + costOp, static: allStatic(lhs, rhs), eval: func(ctx context) (*value, error) {
+		v1, err := lhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v2, err := rhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case v1.isNull() || v2.isNull():
+			return nullValue(), nil
+		case v1.List != nil:
+			return c.contains(ctx, v1.List, v2)
+		case v1.Text != nil && v2.Text != nil:
+			t1, t2 := transformText(c.text, v1), transformText(c.text, v2)
+			return boolValue(strings.Contains(*t1.Text, *t2.Text)), nil
+		}
+		return nil, typeError("CONTAINS", v1, v2)
+	}}), nil
+}
+
+func (c *compiler) compileList(x *List) (*node, error) {
+	items, err := c.compileAll(x.Items)
+	if err != nil {
+		return nil, err
+	}
+	return &node{kind: KindList, cost: totalCost(items...), static: allStatic(items...), eval: func(ctx context) (*value, error) {
+		list := make([]*value, len(items))
+		for i, item := range items {
+			v, err := item.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return &value{List: list}, nil
+	}}, nil
+}
+
+// compileSubquery compiles EXISTS(x WHERE cond) and COUNT(x WHERE cond).
+// The condition is checked against the same schema as the filter.
+func (c *compiler) compileSubquery(x *Subquery) (*node, error) {
+	kind := KindBool
+	switch x.Func {
+	case "exists":
+	case "count":
+		kind = KindNumber
+	default:
+		return nil, fmt.Errorf("%s() cannot be used with WHERE, use exists(x WHERE ...) or count(x WHERE ...)", x.Func)
+	}
+	if x.Source == "" {
+		return nil, fmt.Errorf("%s() expects the rows to query before WHERE, e.g. %s(entries WHERE ...)", x.Func, x.Func)
+	}
+	source, err := c.compileIdent(&Ident{span: x.span, Name: x.Source})
+	if err != nil {
+		return nil, err
+	}
+	if !KindRows.accepts(source.kind) {
+		return nil, fmt.Errorf("%s() expects rows before WHERE, got %s", x.Func, source.kind)
+	}
+	where, err := c.compile(x.Where)
+	if err != nil {
+		return nil, err
+	}
+	exists := x.Func == "exists"
+	return &node{kind: kind, cost: totalCost(source, where) + costSubquery, eval: func(ctx context) (*value, error) {
+		v, err := source.eval(ctx)
+		if err != nil {
+			return nil, err
+		} else if v.isNull() {
+			return v, nil
+		} else if v.Rows == nil {
+			return nil, fmt.Errorf("%s() expects rows before WHERE, got %s", x.Func, v.kind())
+		}
+		rows, err := v.Rows()
+		if err != nil {
+			return nil, err
+		}
+		count := int64(0)
+		for _, row := range rows {
+			rctx := context{get: row, now: ctx.now, tvl: ctx.tvl}
+			if r, err := where.eval(rctx); err != nil {
+				return nil, err
+			} else if r.Bool() {
+				if exists {
+					return boolValue(true), nil
+				}
+				count++
+			}
+		}
+		if exists {
+			return boolValue(false), nil
+		}
+		return numValue(count), nil
+	}}, nil
+}
+
+// element is the operand of a predicate that is applied to each element of
+// the list of ANY() or ALL(). index is the position of the element in
+// context.elems.
+type element struct {
+	span
+	index int
+}
+
+func (x *element) String() string { return "element" }
+
+// isQuantifier checks if n is a call of ANY() or ALL().
+func isQuantifier(n Node) bool {
+	x, ok := n.(*Call)
+	return ok && (strings.EqualFold(x.Name, "any") || strings.EqualFold(x.Name, "all"))
+}
+
+// quantifier returns the ANY() or ALL() that is an operand of the predicate
+// n, nil if there is none, and a function that returns a copy of n with the
+// operand replaced.
+func quantifier(n Node) (*Call, func(Node) Node) {
+	switch x := n.(type) {
+	case *Compare:
+		if isQuantifier(x.Left) {
+			return x.Left.(*Call), func(e Node) Node { c := *x; c.Left = e; return &c }
+		} else if isQuantifier(x.Right) {
+			return x.Right.(*Call), func(e Node) Node { c := *x; c.Right = e; return &c }
+		}
+	case *Between:
+		if isQuantifier(x.Operand) {
+			return x.Operand.(*Call), func(e Node) Node { c := *x; c.Operand = e; return &c }
+		}
+	case *In:
+		if isQuantifier(x.Operand) {
+			return x.Operand.(*Call), func(e Node) Node { c := *x; c.Operand = e; return &c }
+		}
+	case *Contains:
+		if isQuantifier(x.Operand) {
+			return x.Operand.(*Call), func(e Node) Node { c := *x; c.Operand = e; return &c }
+		}
+	case *Like:
+		if isQuantifier(x.Operand) {
+			return x.Operand.(*Call), func(e Node) Node { c := *x; c.Operand = e; return &c }
+		}
+	case *IsNull:
+		if isQuantifier(x.Operand) {
+			return x.Operand.(*Call), func(e Node) Node { c := *x; c.Operand = e; return &c }
+		}
+	}
+	return nil, nil
+}
+
+// compileQuantified compiles a predicate like ANY(list) LIKE "a%" or
+// ALL(list) > 1. The predicate is compiled once, with the quantifier replaced
+// by an element, and evaluated for each element of the list. ANY is true if
+// the predicate is true for one of the elements, ALL if it is true for all of
+// them (or if the list is empty).
+func (c *compiler) compileQuantified(n Node, q *Call, replace func(Node) Node) (*node, error) {
+	name := strings.ToLower(q.Name)
+	if len(q.Args) != 1 {
+		return nil, fmt.Errorf("%s() expects 1 argument(s), got %d", name, len(q.Args))
+	}
+	list, err := c.compile(q.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !KindList.accepts(list.kind) {
+		return nil, fmt.Errorf("%s() expects list as argument 1, got %s", name, list.kind)
+	}
+	// elements that were already replaced belong to the same predicate, e.g.
+	// in ANY(a) = ANY(b), and keep their position
+	index := 0
+	Walk(n, func(n Node) bool {
+		if _, ok := n.(*element); ok {
+			index++
+		}
+		return true
+	})
+	pred, err := c.compile(replace(&element{span: q.span, index: index}))
+	if err != nil {
+		return nil, err
+	}
+	// ANY is decided by the first true result like OR, ALL by the first
+	// false one like AND
+	decisive := name == "any"
+	return &node{kind: KindBool, cost: totalCost(list, pred) + costOp, static: allStatic(list, pred), eval: func(ctx context) (*value, error) {
+		v, err := list.eval(ctx)
+		if err != nil {
+			return nil, err
+		} else if v.isNull() {
+			return v, nil
+		} else if v.List == nil {
+			return nil, fmt.Errorf("%s() expects list as argument 1, got %s", name, v.kind())
+		}
+		ctx.elems = append(make([]*value, 0, index+1), ctx.elems[:min(index, len(ctx.elems))]...)
+		ctx.elems = ctx.elems[:index+1]
+		unknown := false
+		for _, e := range v.List {
+			ctx.elems[index] = e
+			r, err := pred.eval(ctx)
+			switch {
+			case err != nil:
+				return nil, err
+			case ctx.tvl && r.isNull():
+				unknown = true
+			case r.Bool() == decisive:
+				return boolValue(decisive), nil
+			}
+		}
+		if unknown {
+			return nullValue(), nil
+		}
+		return boolValue(!decisive), nil
+	}}, nil
+}
+
+func (c *compiler) compileLike(x *Like) (*node, error) {
+	lhs, err := c.compile(x.Operand)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.compile(x.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	if lhs.kind == KindList {
+		// the elements are matched with ANY(list) LIKE pattern
+		return nil, kindError(x.Op, lhs.kind, p.kind)
+	}
+	escape, err := c.compileEscape(x)
+	if err != nil {
+		return nil, err
+	}
+	// the case-insensitive operators fold the text and the pattern instead of
+	// using (?i), which only applies simple case folding
+	toRegex := func(s string) (*regexp.Regexp, error) { return likeToRegex(s, escape) }
+	var text func(string) string
+	cost := costLike
+	switch x.Op {
+	case "ILIKE":
+		text = c.fold
+	case "RLIKE":
+		toRegex = regexp.Compile
+		cost = costRegex
+	case "GLOB":
+		toRegex, text = globToRegex, c.norm
+	case "IGLOB":
+		toRegex, text = globToRegex, c.fold
+	default:
+		text = c.text
+	}
+	compilePattern := func(s string) (*regexp.Regexp, error) {
+		p := s
+		if text != nil {
+			p = text(s)
+		}
+		re, err := toRegex(p)
+		if err != nil {
+			return nil, &PatternError{Pattern: s, Pos: x.Pattern.Pos(), End: x.Pattern.End(), Err: err}
+		}
+		return re, nil
+	}
+
+	// static patterns are compiled once, others on evaluation using a cache
+	var re *regexp.Regexp
+	if p.static {
+		if v2, err := p.eval(context{}); err == nil && !v2.isNull() {
+			if re, err = compilePattern(v2.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if re == nil {
+		cache, err := lru.New[string, *regexp.Regexp](patternCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		compileUncached := compilePattern
+		compilePattern = func(s string) (*regexp.Regexp, error) {
+			if re, ok := cache.Get(s); ok {
+				return re, nil
+			}
+			re, err := compileUncached(s)
+			if err == nil {
+				cache.Add(s, re)
+			}
+			return re, err
+		}
+	}
+	return withNot(x.Not, &node{kind: KindBool, cost: totalCost(lhs, p) + cost, static: lhs.static && p.static, eval: func(ctx context) (*value, error) {
+		re := re
+		if re == nil {
+			v2, err := p.eval(ctx)
+			if err != nil {
+				return nil, err
+			} else if v2.isNull() {
+				return v2, nil
+			}
+			if re, err = compilePattern(v2.String()); err != nil {
+				return nil, err
+			}
+		}
+		v1, err := lhs.eval(ctx)
+		if err != nil {
+			return nil, err
+		} else if v1.isNull() {
+			return v1, nil
+		}
+		s := v1.String()
+		if text != nil {
+			s = text(s)
+		}
+		return boolValue(re.MatchString(s)), nil
+	}}), nil
+}
+
+func (c *compiler) compileCase(x *Case) (*node, error) {
+	var operand *node
+	if x.Operand != nil {
+		var err error
+		if operand, err = c.compile(x.Operand); err != nil {
+			return nil, err
+		}
+	}
+	conds := make([]*node, len(x.Whens))
+	results := make([]*node, len(x.Whens))
+	for i, w := range x.Whens {
+		var err error
+		if conds[i], err = c.compile(w.Cond); err != nil {
+			return nil, err
+		}
+		if operand != nil && !comparableKinds(operand.kind, conds[i].kind) {
+			return nil, kindError("CASE", operand.kind, conds[i].kind)
+		}
+		if results[i], err = c.compile(w.Result); err != nil {
+			return nil, err
+		}
+	}
+	els := &node{kind: KindNull, static: true, eval: func(ctx context) (*value, error) { return nullValue(), nil }}
+	if x.Else != nil {
+		var err error
+		if els, err = c.compile(x.Else); err != nil {
+			return nil, err
+		}
+	}
+	return c.conditional(operand, conds, results, els), nil
+}
+
+// conditional evaluates to the first result whose condition is true (or equal
+// to operand if it is not nil) and to els if there is none. Only the chosen
+// result is evaluated.
+func (c *compiler) conditional(operand *node, conds, results []*node, els *node) *node {
+	all := append(append([]*node{els}, conds...), results...)
+	if operand != nil {
+		all = append(all, operand)
+	}
+	kind := KindNull
+	for _, r := range append([]*node{els}, results...) {
+		if kind == KindNull {
+			kind = r.kind
+		} else if r.kind != kind && r.kind != KindNull {
+			kind = KindAny
+		}
+	}
+	return &node{kind: kind, cost: totalCost(all...) + costOp, static: allStatic(all...), eval: func(ctx context) (*value, error) {
+		var v1 *value
+		if operand != nil {
+			var err error
+			if v1, err = operand.eval(ctx); err != nil {
+				return nil, err
+			}
+			v1 = transformText(c.text, v1)
+		}
+		for i, cond := range conds {
+			v, err := cond.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			match := v.Bool()
+			if operand != nil {
+				// like =, a NULL operand does not match any value
+				match = false
+				if !v1.isNull() && !v.isNull() {
+					if match, err = equalValues(ctx, v1, transformText(c.text, v)); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if match {
+				return results[i].eval(ctx)
+			}
+		}
+		return els.eval(ctx)
+	}}
+}
+
+// compileEscape returns the escape character of a LIKE pattern, which must be
+// a constant with at most one character. An empty escape disables escaping.
+func (c *compiler) compileEscape(x *Like) (rune, error) {
+	if x.Escape == nil {
+		return '\\', nil
+	}
+	e, err := c.compile(x.Escape)
+	if err != nil {
+		return 0, err
+	}
+	if !e.static {
+		return 0, fmt.Errorf("ESCAPE expects a constant")
+	}
+	v, err := e.eval(context{})
+	if err != nil {
+		return 0, err
+	} else if v.isNull() {
+		return 0, fmt.Errorf("ESCAPE expects a single character, got NULL")
+	}
+	r := []rune(v.String())
+	switch len(r) {
+	case 0:
+		return 0, nil
+	case 1:
+		return r[0], nil
+	}
+	return 0, fmt.Errorf("ESCAPE expects a single character, got \"%s\"", v.String())
+}
+
+// comparableKinds checks if values of the kinds k1 and k2 can be compared,
+// text is compared to a time by parsing it.
+func comparableKinds(k1, k2 Kind) bool {
+	switch {
+	case k1 == KindAny || k2 == KindAny || k1 == KindNull || k2 == KindNull:
+		return true
+	case k1 == KindTime && k2 == KindText, k1 == KindText && k2 == KindTime:
+		return true
+	}
+	return k1 == k2
+}
+
+// arithKind returns the kind of the result of an arithmetic operation, see
+// arith and arithTime.
+func arithKind(op string, k1, k2 Kind) (Kind, error) {
+	switch {
+	case k1 == KindAny || k2 == KindAny || k1 == KindNull || k2 == KindNull:
+		return KindAny, nil
+	case k1 == KindNumber && k2 == KindNumber:
+		return KindNumber, nil
+	case k1 == KindTime && k2 == KindInterval && (op == "+" || op == "-"),
+		k1 == KindInterval && k2 == KindTime && op == "+":
+		return KindTime, nil
+	case k1 == KindTime && k2 == KindTime && op == "-",
+		k1 == KindInterval && k2 == KindInterval && (op == "+" || op == "-"),
+		k1 == KindInterval && k2 == KindNumber && (op == "*" || op == "/"),
+		k1 == KindNumber && k2 == KindInterval && op == "*":
+		return KindInterval, nil
+	}
+	return KindAny, kindError(op, k1, k2)
+}
+
+func (c *compiler) compileArith(x *Arith) (*node, error) {
+	left, err := c.compile(x.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.compile(x.Right)
+	if err != nil {
+		return nil, err
+	}
+	op := x.Op
+	kind, err := arithKind(op, left.kind, right.kind)
+	if err != nil {
+		return nil, err
+	}
+	return &node{kind: kind, cost: totalCost(left, right) + costOp, static: allStatic(left, right), eval: func(ctx context) (*value, error) {
+		v1, err := left.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v2, err := right.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return arith(op, v1, v2)
+	}}, nil
+}
+
+func (c *compiler) compileUnary(x *Unary) (*node, error) {
+	n, err := c.compile(x.Operand)
+	if err != nil {
+		return nil, err
+	}
+	op := x.Op
+	kind := n.kind
+	switch kind {
+	case KindNumber, KindInterval, KindAny, KindNull:
+	default:
+		return nil, fmt.Errorf("cannot apply \"%s\" to %s", op, kind)
+	}
+	return &node{kind: kind, cost: n.cost + costOp, static: n.static, eval: func(ctx context) (*value, error) {
+		v, err := n.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return negate(op, v)
+	}}, nil
+}
+
+func (c *compiler) compileIdent(x *Ident) (*node, error) {
+	name := x.Name
+	kind := KindAny
+	if c.schema != nil {
+		var ok bool
+		if kind, ok = c.schema.lookup(name); !ok {
+			return nil, c.schema.unknownError(name)
+		}
+	}
+	return &node{kind: kind, cost: costSymbol, eval: func(ctx context) (*value, error) {
+		return ctx.get(name).tovalue(name)
+	}}, nil
+}
@@ -1,14 +1,58 @@
 package filter
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"math"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"golang.org/x/text/unicode/norm"
 )
 
 type context struct {
-	get VariableGetter
+	get   VariableGetter
+	now   time.Time
+	tvl   bool
+	score *float64 // best score of the fuzzy matches, nil if not needed
+	elems []*value // the current list elements of ANY() and ALL()
+}
+
+// addScore records the score of a fuzzy match.
+func (ctx context) addScore(s float64) {
+	if ctx.score != nil && s > *ctx.score {
+		*ctx.score = s
+	}
+}
+
+// Option is used to configure a filter in CreateFilter.
+type Option func(*options)
+
+type options struct {
+	now    time.Time
+	tvl    bool
+	schema Schema
+	norm   *norm.Form
+	fold   bool
+}
+
+// WithNow sets the time that is used for now() and to resolve relative dates
+// like "yesterday" or "3 days ago". It defaults to the time when the filter
+// was created.
+func WithNow(now time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// WithThreeValuedLogic enables SQL's three-valued logic where a condition that
+// involves NULL is unknown instead of false. NOT, AND and OR keep the unknown
+// state if the result depends on it (e.g. NOT (NULL = 1) is unknown and not
+// true). The filter only matches if the final result is true.
+func WithThreeValuedLogic() Option {
+	return func(o *options) { o.tvl = true }
 }
 
 // VariableGetter is a function type that is used to retrieve the value of a variable
@@ -18,27 +62,167 @@ type VariableGetter func(name string) *Value
 
 var ErrInvalidOperatorOrOperands = errors.New("invalid operator or operands")
 
-func (x *term) eval(ctx context) (*value, error) {
-	switch {
-	case x.Value != nil:
-		return x.Value, nil
-	case x.SymbolRef != nil:
-		return ctx.get(x.SymbolRef.Symbol).tovalue(x.SymbolRef.Symbol)
+var ErrDivisionByZero = errors.New("division by zero")
+
+var ErrOverflow = errors.New("integer overflow")
+
+// Position is a location in the filter string.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number, starting at 1
+}
+
+func position(p lexer.Position) Position {
+	return Position{Offset: p.Offset, Line: p.Line, Column: p.Column}
+}
+
+// PatternError is returned when a LIKE, ILIKE or RLIKE pattern is invalid. Pos
+// and End enclose the pattern expression in the filter string.
+type PatternError struct {
+	Pattern string
+	Pos     Position
+	End     Position
+	Err     error
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("%d:%d: invalid pattern \"%s\": %v", e.Pos.Line, e.Pos.Column, e.Pattern, e.Err)
+}
+
+func (e *PatternError) Unwrap() error { return e.Err }
+
+// CompileError is returned when a filter can be parsed but not compiled, e.g.
+// because it uses an unknown name or applies an operator to values of kinds
+// that do not fit. Pos and End enclose the part of the filter that caused it.
+type CompileError struct {
+	Pos Position
+	End Position
+	Err error
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%d:%d: %v", e.Pos.Line, e.Pos.Column, e.Err)
+}
+
+func (e *CompileError) Unwrap() error { return e.Err }
+
+func typeError(op string, v1, v2 *value) error {
+	return kindError(op, v1.kind(), v2.kind())
+}
+
+func kindError(op string, k1, k2 Kind) error {
+	return fmt.Errorf("cannot apply \"%s\" to %s and %s", op, k1, k2)
+}
+
+func arithFloat(op string, f1, f2 float64) (*value, error) {
+	var r float64
+	switch op {
+	case "+":
+		r = f1 + f2
+	case "-":
+		r = f1 - f2
+	case "*":
+		r = f1 * f2
+	case "/", "%":
+		if f2 == 0 {
+			return nil, ErrDivisionByZero
+		}
+		if op == "/" {
+			r = f1 / f2
+		} else {
+			r = math.Mod(f1, f2)
+		}
 	default:
-		return x.SubExpression.eval(ctx)
+		return nil, ErrInvalidOperatorOrOperands
 	}
+	return &value{Float: &r}, nil
 }
 
-func (x *compare) eval(t *term, ctx context) (*value, error) {
-	v1, err := t.eval(ctx)
-	if err != nil {
-		return nil, err
+func arith(op string, v1, v2 *value) (*value, error) {
+	if v1.isNull() || v2.isNull() {
+		return nullValue(), nil
 	}
-	v2, err := x.Operand.eval(ctx)
-	if err != nil {
-		return nil, err
+	if v1.isNum() && v2.isNum() && (v1.Float != nil || v2.Float != nil) {
+		return arithFloat(op, v1.float(), v2.float())
+	}
+	if v1.Num() == nil || v2.Num() == nil {
+		return arithTime(op, v1, v2)
+	}
+	n1, n2 := *v1.Num(), *v2.Num()
+	var r int64
+	switch op {
+	case "+":
+		r = n1 + n2
+		if (n2 > 0 && r < n1) || (n2 < 0 && r > n1) {
+			return nil, ErrOverflow
+		}
+	case "-":
+		r = n1 - n2
+		if (n2 > 0 && r > n1) || (n2 < 0 && r < n1) {
+			return nil, ErrOverflow
+		}
+	case "*":
+		r = n1 * n2
+		if n1 != 0 && (r/n1 != n2 || (n1 == -1 && n2 == math.MinInt64)) {
+			return nil, ErrOverflow
+		}
+	case "/", "%":
+		if n2 == 0 {
+			return nil, ErrDivisionByZero
+		}
+		if n1 == math.MinInt64 && n2 == -1 {
+			if op == "%" {
+				return &value{Number: new(int64)}, nil
+			}
+			return nil, ErrOverflow
+		}
+		if op == "/" {
+			r = n1 / n2
+		} else {
+			r = n1 % n2
+		}
+	default:
+		return nil, ErrInvalidOperatorOrOperands
+	}
+	return &value{Number: &r}, nil
+}
+
+func negate(op string, v *value) (*value, error) {
+	if v.isNull() {
+		return v, nil
+	}
+	if v.Interval != nil {
+		r := *v.Interval
+		if op == "-" {
+			r = -r
+		}
+		return &value{Interval: &r}, nil
+	}
+	if v.Float != nil {
+		r := *v.Float
+		if op == "-" {
+			r = -r
+		}
+		return &value{Float: &r}, nil
+	}
+	if v.Num() == nil {
+		return nil, fmt.Errorf("cannot apply \"%s\" to %s", op, v.kind())
+	}
+	r := *v.Num()
+	if op == "-" {
+		if r == math.MinInt64 {
+			return nil, ErrOverflow
+		}
+		r = -r
+	}
+	return &value{Number: &r}, nil
+}
+
+func compareValues(ctx context, op string, v1, v2 *value) (*value, error) {
+	if v1.isNull() || v2.isNull() {
+		return nullValue(), nil
 	}
-	op := x.Operator
 	r := false
 
 	switch {
@@ -61,8 +245,11 @@ func (x *compare) eval(t *term, ctx context) (*value, error) {
 			return nil, ErrInvalidOperatorOrOperands
 		}
 		return boolValue(r), nil
+	case v1.isNum() && v2.isNum():
+		return cmpResult(op, cmp.Compare(v1.float(), v2.float()))
 	case v1.Text != nil && v2.Text != nil:
-		t1, t2 := *v1.Text, *v2.Text
+		t1 := ctx.resolveDateText(*v2.Text, *v1.Text)
+		t2 := ctx.resolveDateText(*v1.Text, *v2.Text)
 		switch op {
 		case "!=", "<>":
 			r = t1 != t2
@@ -91,188 +278,145 @@ func (x *compare) eval(t *term, ctx context) (*value, error) {
 			return nil, ErrInvalidOperatorOrOperands
 		}
 		return boolValue(r), nil
+	case v1.Time != nil || v2.Time != nil:
+		t1, err := v1.asTime(ctx.now)
+		if err != nil {
+			return nil, err
+		}
+		t2, err := v2.asTime(ctx.now)
+		if err != nil {
+			return nil, err
+		}
+		return cmpResult(op, t1.Compare(t2))
+	case v1.Interval != nil && v2.Interval != nil:
+		return cmpResult(op, cmp.Compare(*v1.Interval, *v2.Interval))
+	case v1.List != nil && v2.List != nil && (op == "=" || op == "!="):
+		eq, err := equalValues(ctx, v1, v2)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(eq == (op == "=")), nil
 	}
 	return nil, ErrInvalidOperatorOrOperands
 }
 
-func (x *between) eval(t *term, ctx context) (*value, error) {
-	v1, err := t.eval(ctx)
-	if err != nil {
-		return nil, err
-	}
-	v2, err := x.Start.eval(ctx)
-	if err != nil {
-		return nil, err
-	}
-	v3, err := x.End.eval(ctx)
-	if err != nil {
-		return nil, err
+func betweenValues(ctx context, v1, v2, v3 *value) (*value, error) {
+	if v1.isNull() || v2.isNull() || v3.isNull() {
+		return nullValue(), nil
 	}
 
 	switch {
 	case v1.Num() != nil && v2.Num() != nil && v3.Num() != nil:
 		n1, n2, n3 := *v1.Num(), *v2.Num(), *v3.Num()
 		return boolValue(n1 >= n2 && n1 <= n3), nil
+	case v1.isNum() && v2.isNum() && v3.isNum():
+		f1, f2, f3 := v1.float(), v2.float(), v3.float()
+		return boolValue(f1 >= f2 && f1 <= f3), nil
 	case v1.Text != nil && v2.Text != nil && v3.Text != nil:
-		t1, t2, t3 := *v1.Text, *v2.Text, *v3.Text
+		t1 := *v1.Text
+		t2, t3 := ctx.resolveDateText(t1, *v2.Text), ctx.resolveDateText(t1, *v3.Text)
 		return boolValue(t1 >= t2 && t1 <= t3), nil
-	}
-	return nil, ErrInvalidOperatorOrOperands
-}
-
-func (x *in) eval(t *term, ctx context) (*value, error) {
-	v1, err := t.eval(ctx)
-	if err != nil {
-		return nil, err
-	}
-	for _, o := range x.Expressions {
-		if v2, err := o.eval(ctx); err != nil {
+	case v1.Time != nil:
+		t2, err := v2.asTime(ctx.now)
+		if err != nil {
 			return nil, err
-		} else {
-			switch {
-			case v1.Num() != nil && v2.Num() != nil:
-				n1, n2 := *v1.Num(), *v2.Num()
-				if n1 == n2 {
-					return boolValue(true), nil
-				}
-			case v1.Text != nil && v2.Text != nil:
-				t1, t2 := *v1.Text, *v2.Text
-				if t1 == t2 {
-					return boolValue(true), nil
-				}
-			case v1.Boolean != nil && v2.Boolean != nil:
-				b1, b2 := v1.Bool(), v2.Bool()
-				if b1 == b2 {
-					return boolValue(true), nil
-				}
-			default:
-				fmt.Println(v1, v2)
-				return nil, ErrInvalidOperatorOrOperands
-			}
 		}
+		t3, err := v3.asTime(ctx.now)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(!v1.Time.Before(t2) && !v1.Time.After(t3)), nil
+	case v1.Interval != nil && v2.Interval != nil && v3.Interval != nil:
+		d1, d2, d3 := *v1.Interval, *v2.Interval, *v3.Interval
+		return boolValue(d1 >= d2 && d1 <= d3), nil
 	}
-	return boolValue(false), nil
-}
-
-func not(value *value, err error) (*value, error) {
-	return boolValue(!value.Bool()), err
-}
-
-func likeToRegex(text string, caseInsensitive bool) *regexp.Regexp {
-	ex := regexp.QuoteMeta(text)
-	ex = strings.ReplaceAll(ex, "%", ".*")
-	ex = strings.ReplaceAll(ex, "_", ".")
-	ex = "^" + ex + "$"
-	if caseInsensitive {
-		ex = "(?i)^" + ex
-	}
-	return regexp.MustCompile(ex)
+	return nil, ErrInvalidOperatorOrOperands
 }
 
-func (x *conditionRHS) eval(t *term, ctx context) (*value, error) {
-	r := false
+// equalValues is used by IN, v1 and v2 must not be NULL.
+func equalValues(ctx context, v1, v2 *value) (bool, error) {
 	switch {
-	case x.Compare != nil:
-		return x.Compare.eval(t, ctx)
-	case x.Between != nil:
-		if x.Not {
-			return not(x.Between.eval(t, ctx))
-		} else {
-			return x.Between.eval(t, ctx)
+	case v1.Num() != nil && v2.Num() != nil:
+		return *v1.Num() == *v2.Num(), nil
+	case v1.isNum() && v2.isNum():
+		return v1.float() == v2.float(), nil
+	case v1.Text != nil && v2.Text != nil:
+		return *v1.Text == *v2.Text, nil
+	case v1.Boolean != nil && v2.Boolean != nil:
+		return v1.Bool() == v2.Bool(), nil
+	case v1.Time != nil:
+		t2, err := v2.asTime(ctx.now)
+		if err != nil {
+			return false, err
 		}
-	case x.In != nil:
-		if x.Not {
-			return not(x.In.eval(t, ctx))
-		} else {
-			return x.In.eval(t, ctx)
+		return v1.Time.Equal(t2), nil
+	case v1.Interval != nil && v2.Interval != nil:
+		return *v1.Interval == *v2.Interval, nil
+	case v1.List != nil && v2.List != nil:
+		if len(v1.List) != len(v2.List) {
+			return false, nil
 		}
-	}
-
-	// *like
-	// assume regex is static
-	if x.likeCache == nil {
-		switch {
-		case x.Like != nil:
-			v2, err := x.Like.eval(ctx)
-			if err != nil {
-				return nil, err
-			}
-			x.likeCache = likeToRegex(v2.String(), false)
-		case x.Ilike != nil:
-			v2, err := x.Ilike.eval(ctx)
-			if err != nil {
-				return nil, err
-			}
-			x.likeCache = likeToRegex(v2.String(), true)
-		case x.Rlike != nil:
-			v2, err := x.Rlike.eval(ctx)
-			if err != nil {
-				return nil, err
+		for i := range v1.List {
+			e1, e2 := v1.List[i], v2.List[i]
+			if e1.isNull() || e2.isNull() {
+				if e1.isNull() != e2.isNull() {
+					return false, nil
+				}
+			} else if eq, err := equalValues(ctx, e1, e2); err != nil || !eq {
+				return false, err
 			}
-			x.likeCache = regexp.MustCompile(v2.String())
 		}
+		return true, nil
 	}
+	return false, ErrInvalidOperatorOrOperands
+}
 
-	v1, err := t.eval(ctx)
+func (ctx context) not(v *value, err error) (*value, error) {
 	if err != nil {
 		return nil, err
 	}
-	r = x.likeCache.MatchString(v1.String())
-	if x.Not {
-		r = !r
-	}
-	return boolValue(r), nil
-}
-
-func (x *conditionOperand) eval(ctx context) (*value, error) {
-	if x.ConditionRHS != nil {
-		return x.ConditionRHS.eval(x.Operand, ctx)
-	} else {
-		return x.Operand.eval(ctx)
-	}
-}
-
-func (x *condition) eval(ctx context) (*value, error) {
-	switch {
-	case x.Operand != nil:
-		return x.Operand.eval(ctx)
-	default:
-		if v, err := x.Not.eval(ctx); err != nil {
-			return nil, err
-		} else {
-			return boolValue(!v.Bool()), nil
-		}
-	}
-}
-
-func (x *andCondition) eval(ctx context) (*value, error) {
-	r := true
-	for _, o := range x.And {
-		if v, err := o.eval(ctx); err != nil {
-			return nil, err
-		} else {
-			r = r && v.Bool()
-		}
+	if ctx.tvl && v.isNull() {
+		return v, nil
 	}
-	return boolValue(r), nil
+	return boolValue(!v.Bool()), nil
 }
 
-func (x *expression) eval(ctx context) (*value, error) {
-	r := false
-	for _, o := range x.Or {
-		if v, err := o.eval(ctx); err != nil {
-			return nil, err
-		} else {
-			r = r || v.Bool()
+// likeToRegex converts a LIKE pattern to a regular expression. "%" matches
+// any text and "_" a single character, unless they follow the escape
+// character. An escape of 0 disables escaping.
+func likeToRegex(text string, escape rune) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	r := []rune(text)
+	for i := 0; i < len(r); i++ {
+		switch c := r[i]; {
+		case c == escape && escape != 0:
+			if i+1 >= len(r) {
+				return nil, errors.New("pattern ends with the escape character")
+			}
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(r[i])))
+		case c == '%':
+			sb.WriteString(".*")
+		case c == '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
 		}
 	}
-	return boolValue(r), nil
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
 }
 
 // FilterExpression is a parsed and compiled representation of a filter string.
 // It can be used to efficiently test whether a set of variables matches the filter.
+// A FilterExpression is safe for concurrent use by multiple goroutines.
 type FilterExpression struct {
-	expression *expression
+	ast  Node
+	eval evalFunc
+	now  time.Time
+	tvl  bool
+	c    *compiler
 }
 
 // Test tests whether the set of variables provided by the getter function matches
@@ -280,21 +424,106 @@ type FilterExpression struct {
 // match the filter, as well as an error value if there was a problem evaluating the
 // expression, like a type mismatch or a missing variable.
 func (x *FilterExpression) Test(getter VariableGetter) (bool, error) {
-	ctx := context{get: getter}
-	if r, err := x.expression.eval(ctx); err != nil {
+	ctx := context{get: getter, now: x.now, tvl: x.tvl}
+	if r, err := x.eval(ctx); err != nil {
 		return false, err
 	} else {
 		return r.Bool(), nil
 	}
 }
 
+// Eval evaluates the filter as an expression and returns its value instead
+// of testing it, e.g. the text that a CASE expression selects.
+func (x *FilterExpression) Eval(getter VariableGetter) (*Value, error) {
+	ctx := context{get: getter, now: x.now, tvl: x.tvl}
+	r, err := x.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.export(), nil
+}
+
+// Score is like Test and also returns the best score (from 0 to 1) of the
+// fuzzy matches in the filter, using the ~ operator or similarity(). It
+// returns 0 if the filter has no fuzzy match. Unlike Test, all operands of AND
+// and OR are evaluated.
+func (x *FilterExpression) Score(getter VariableGetter) (bool, float64, error) {
+	score := 0.0
+	ctx := context{get: getter, now: x.now, tvl: x.tvl, score: &score}
+	r, err := x.eval(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	return r.Bool(), score, nil
+}
+
 // CreateFilter parses the given filter string and returns a compiled FilterExpression
 // that can be used to efficiently test the filter. If the filter string is not valid,
 // an error is returned.
-func CreateFilter(filter string) (*FilterExpression, error) {
-	if expr, err := parser.ParseString("", filter); err != nil {
+func CreateFilter(filter string, opts ...Option) (*FilterExpression, error) {
+	o := options{now: time.Now()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := o.compiler()
+	if ast, err := Parse(filter); err != nil {
+		return nil, err
+	} else if n, err := c.compile(ast); err != nil {
 		return nil, err
 	} else {
-		return &FilterExpression{expression: expr}, nil
+		return &FilterExpression{ast: ast, eval: n.eval, now: o.now, tvl: o.tvl, c: c}, nil
+	}
+}
+
+// Bind returns a copy of the filter where the parameters (":name" or "?")
+// are replaced by values, the filter itself is not changed. The keys of
+// values are the names of the parameters without the colon, or "1", "2", ...
+// for the "?" parameters in the order of their appearance. Values are never
+// parsed, so they cannot change the structure of the filter. All parameters
+// must be bound and rows cannot be bound because they have no literal form.
+func (x *FilterExpression) Bind(values map[string]*Value) (*FilterExpression, error) {
+	params := x.Params()
+	for key, v := range values {
+		if !slices.Contains(params, key) {
+			return nil, fmt.Errorf("unknown parameter \"%s\"", key)
+		}
+		if v.Kind() == KindRows {
+			return nil, fmt.Errorf("parameter \"%s\" cannot be bound to rows", key)
+		}
+	}
+	var err error
+	ast := rewrite(x.ast, func(n Node) Node {
+		p, ok := n.(*Param)
+		if !ok {
+			return n
+		}
+		v, ok := values[p.Key()]
+		if !ok {
+			err = fmt.Errorf("parameter \"%s\" is not bound", p.Key())
+		}
+		return &Literal{span: p.span, Value: v}
+	})
+	if err != nil {
+		return nil, err
+	}
+	n, err := x.c.compile(ast)
+	if err != nil {
+		return nil, err
 	}
+	return &FilterExpression{ast: ast, eval: n.eval, now: x.now, tvl: x.tvl, c: x.c}, nil
 }
+
+// AST returns the syntax tree of the filter. It must not be modified.
+func (x *FilterExpression) AST() Node { return x.ast }
+
+// String returns the filter in canonical form.
+func (x *FilterExpression) String() string { return x.ast.String() }
+
+// Fields returns the names of the variables that the filter refers to.
+func (x *FilterExpression) Fields() []string { return Fields(x.ast) }
+
+// Params returns the keys of the parameters that must be bound with Bind.
+func (x *FilterExpression) Params() []string { return Params(x.ast) }
+
+// Now returns the time that is used for now() and relative dates, see WithNow.
+func (x *FilterExpression) Now() time.Time { return x.now }
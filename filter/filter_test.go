@@ -1,8 +1,14 @@
 package filter
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 type example struct {
@@ -36,14 +42,216 @@ var examples = []example{
 	{false, "", "x not between 3 and 5"},
 	{false, "", "x between 4 and 5"},
 	{true, "", "x=3 and y<70K"},
+	{true, "", "c.name like \"%.zip\" and name = \"foobar\""},
+	{false, "\"c.size\" is unknown", "c.size > 0"},
+	{true, "", "\"node_modules\" in l"},
+	{true, "", "\"src\" in (\"a\", l)"},
+	{false, "", "\"lib\" in l"},
+	{true, "", "\"lib\" not in l"},
+	{true, "", "x in [1, 3]"},
+	{true, "", "l contains \"src\""},
+	{false, "", "el contains \"src\""},
+	{true, "", "name contains \"oba\""},
+	{false, "", "name contains \"x\""},
+	{true, "", "name not contains \"x\""},
+	{true, "", "any(l) like \"node%\""},
+	{false, "", "all(l) like \"node%\""},
+	{true, "", "all(l) like \"%\""},
+	{true, "", "\"src\" = any(l)"},
+	{true, "", "all(el) = 1"},
+	{false, "", "any(el) = 1"},
+	{true, "", "any([1, 5, 9]) between 4 and 6"},
+	{true, "", "all([1, 2]) < any([2, 3])"},
+	{true, "", "any(l) = any([\"a\", \"src\"])"},
+	{true, "", "any([null, 1]) is null"},
+	{true, "", "cardinality(l) = 2 and cardinality([]) = 0"},
+	{true, "", "[1, \"a\"] = [1, \"a\"] and [1, 2] != [2, 1]"},
+	{false, "1:1: any() must be the operand of a comparison, e.g. ANY(x) = 1", "any(l)"},
+	{false, "all() expects list as argument 1, got text", "all(name) = 1"},
+	{false, "cannot apply \"CONTAINS\" to number and number", "x contains 1"},
 	{true, "", "x=5 and y=40000 or name=\"foobar\""},
 	{false, "", "x=5 and (y=40000 or name=\"foobar\")"},
 	{false, "\"noname\" is unknown", "noname like \"hug%\""},
-	{true, "\"i\" is unknown", "x=5 and (i=7 or foo='foo')"},
+	{false, "", "x=5 and (i=7 or foo='foo')"},
+	{false, "\"i\" is unknown", "x=3 and (i=7 or foo='foo')"},
+	{true, "", "x=3 or i=7"},
+	{false, "", "noname like \"hug%\" and x=5"},
+	{true, "", "noname rlike \"^foo\" or x=3"},
+	{false, "1:12: invalid pattern \"(\": error parsing regexp: missing closing ): `(`", "name rlike \"(\""},
+	{false, "1:12: invalid pattern \"(3\": error parsing regexp: missing closing ): `(3`", "name rlike concat(\"(\", x)"},
+	{false, "", "x=5 and name rlike concat(\"(\", x)"},
+	{true, "", "name like \"(%\" or name ilike \"F[OO]%\" or x=3"},
+	{true, "", "e like e"},
+	{true, "", "name glob \"foo*\""},
+	{true, "", "name glob \"f?o*\""},
+	{false, "", "name glob \"*.txt\""},
+	{true, "", "name not glob \"*.txt\""},
+	{false, "", "name glob \"FOO*\""},
+	{true, "", "name iglob \"FOO*\""},
+	{true, "", "name glob \"[a-f]oo{bar,baz}\""},
+	{false, "", "name glob \"[!f]*\""},
+	{true, "", "name glob \"{x,fo{o,x}}*\""},
+	{false, "", "name glob \"foo\\\\*\""},
+	{false, "", "concat(\"a/b/\", name) glob \"a/*\""},
+	{true, "", "concat(\"a/b/\", name) glob \"a/**\""},
+	{true, "", "concat(\"a/b/\", name) glob \"**/foobar\""},
+	{true, "", "concat(\"a/\", name) glob \"a/**/foobar\""},
+	{true, "", "name glob \"**/foobar\""},
+	{false, "1:11: invalid pattern \"[ab\": missing closing ]", "name glob \"[ab\""},
+	{false, "1:11: invalid pattern \"{a,b\": missing closing }", "name glob \"{a,b\""},
+	{false, "", "name like e"},
+	{true, "", "name like concat(substr(name, 1, 3), \"%\")"},
+	{true, "", "name rlike concat(e, \"bar$\")"},
+	{true, "", "\"100%_done.txt\" like \"100\\%\\_done.txt\""},
+	{false, "", "\"100x_done.txt\" like \"100\\%\\_done.txt\""},
+	{true, "", "\"100x_done.txt\" like \"100%\\_done.txt\""},
+	{true, "", "\"50%\" like '50\\%' escape '\\'"},
+	{true, "", "\"100%_done.txt\" ilike \"100!%!_DONE.txt\" escape \"!\""},
+	{false, "", "\"100x_done.txt\" like \"100!%_done.txt\" escape '!'"},
+	{true, "", "\"a\\\\b\" like \"a\\\\\\\\b\""},
+	{true, "", "\"a\\\\b\" like \"a\\\\b\" escape \"\""},
+	{false, "1:1: ESCAPE expects a single character, got \"!!\"", "name like \"foo%\" escape \"!!\""},
+	{false, "1:1: ESCAPE expects a constant", "name like \"foo%\" escape name"},
+	{false, "1:11: invalid pattern \"foo\\\": pattern ends with the escape character", "name like 'foo\\'"},
 	{false, "invalid operator or operands", "x=\"x\""},
+	{true, "", "x+1=4"},
+	{true, "", "x=-3+6"},
+	{true, "", "x-5=-2"},
+	{true, "", "2+x*3=11"},
+	{true, "", "(2+x)*3=15"},
+	{true, "", "y/x=13333"},
+	{true, "", "y%x=1"},
+	{true, "", "y*2 > 70K"},
+	{true, "", "y/1K between 39 and 40"},
+	{true, "", "x in (1+1, 1+2)"},
+	{true, "", "-x<0"},
+	{false, "cannot apply \"+\" to text and number", "name+1=2"},
+	{false, "cannot apply \"-\" to text", "-name=1"},
+	{false, "division by zero", "y/(x-3)=1"},
+	{false, "integer overflow", "y > 1G * 1G * 1G"},
+	{false, "integer overflow", "9223372036854775807 + x > 0"},
+	{false, "integer overflow", "-9223372036854775807 - x < 0"},
+	{true, "", "length(name)=6"},
+	{true, "", "upper(name)=\"FOOBAR\""},
+	{true, "", "LOWER(upper(name))=name"},
+	{true, "", "substr(name, 1, 3)=\"foo\""},
+	{true, "", "substr(name, 4)=\"bar\""},
+	{true, "", "substr(name, 5, 10)=\"ar\""},
+	{true, "", "replace(name, \"o\", \"0\")=\"f00bar\""},
+	{true, "", "concat(name, \"-\", x)=\"foobar-3\""},
+	{true, "", "coalesce(n, name)=\"foobar\""},
+	{true, "", "coalesce(e, name)=\"\""},
+	{true, "", "abs(-x)=x"},
+	{false, "integer overflow", "abs(-9223372036854775807 - 1) > 0"},
+	{true, "", "length(name) > 5 and abs(x-5) in (1, 2)"},
+	{false, "1:1: unknown function \"foo\"", "foo(name)"},
+	{false, "1:1: lower() expects 1 argument(s), got 2", "lower(name, 1)"},
+	{false, "1:1: substr() expects 2 to 3 argument(s), got 1", "substr(name)"},
+	{false, "lower() expects text as argument 1, got number", "lower(x)=\"3\""},
+	{false, "lower() expects text as argument 1, got number", "lower(1+x)=\"4\""},
+	{false, "1:1: substr() expects number as argument 2, got text", "substr(name, \"1\")"},
+	{false, "1:1: abs() expects number as argument 1, got text", "abs(lower(name))=1"},
+	{true, "", "t > \"2023\""},
+	{true, "", "t = \"2024-01-01 12:00:00\""},
+	{true, "", "t between \"2024-01-01 08:00\" and \"2024-01-01 18:00\""},
+	{false, "", "t not between \"2024-01-01 08:00\" and \"2024-01-01 18:00\""},
+	{true, "", "t in (\"2020\", \"2024-01-01 12:00\")"},
+	{true, "", "t - interval 1 day < \"2024-01-01\""},
+	{true, "", "t + interval 2 hours = \"2024-01-01 14:00\""},
+	{true, "", "interval 1 week + t = \"2024-01-08 12:00\""},
+	{true, "", "t - interval 30 minutes between \"2024-01-01 11:00\" and \"2024-01-01 12:00\""},
+	{true, "", "now() - interval 7 days > t"},
+	{true, "", "now() - t > interval 1 day"},
+	{true, "", "t - (t - interval 36 hours) = interval 1 day + interval 12 hours"},
+	{true, "", "interval 2 days = interval 48 hours"},
+	{true, "", "interval 1 day * 2 = 2 * interval 1 day"},
+	{true, "", "-interval 1 hour < interval 1 second"},
+	{false, "invalid time \"abc\"", "t > \"abc\""},
+	{false, "cannot apply \"+\" to time and number", "t + 1 > t"},
+	{true, "", "r > 0.5"},
+	{true, "", "r = .75"},
+	{true, "", "r between 0.5 and 1"},
+	{false, "", "r not between 0 and 1"},
+	{true, "", "r in (0.5, 0.75)"},
+	{true, "", "x in (3.0, 5)"},
+	{true, "", "x = 3.0"},
+	{true, "", "2e3 = 2000"},
+	{true, "", "1.5e-1 < 0.2"},
+	{false, "", "1.5 = 3/2"},
+	{true, "", "1.5 = 3/2.0"},
+	{true, "", "1.5 * 2 = 3"},
+	{true, "", "x * 0.5 = 1.5"},
+	{true, "", "y % 7.5 = 2.5"},
+	{true, "", "r * 1K = 768"},
+	{true, "", "1.5K = 1536"},
+	{true, "", "-r < 0"},
+	{true, "", "abs(-r) = r"},
+	{true, "", "substr(name, 2.0, 2) = \"oo\""},
+	{true, "", "interval 1 day * 0.5 = interval 12 hours"},
+	{true, "", "interval 1.5 hours = interval 90 minutes"},
+	{false, "integer overflow", "interval 1 week * 1e30 > t - t"},
+	{false, "division by zero", "r / 0 = 1"},
+	{false, "invalid operator or operands", "r = \"0.75\""},
+	{true, "", "n is null"},
+	{false, "", "n is not null"},
+	{false, "", "x is null"},
+	{true, "", "e is not null"},
+	{true, "", "null is null"},
+	{true, "", "n + 1 is null"},
+	{true, "", "-n is null"},
+	{true, "", "lower(n) is null"},
+	{true, "", "concat(name, n) is null"},
+	{false, "", "n = 1"},
+	{false, "", "n != 1"},
+	{true, "", "not (n = 1)"},
+	{false, "", "n between 1 and 2"},
+	{true, "", "n not between 1 and 2"},
+	{false, "", "n in (1, 2)"},
+	{true, "", "x in (n, 3)"},
+	{true, "", "x not in (n, 4)"},
+	{false, "", "n like \"%\""},
+	{true, "", "n not like \"%\""},
+	{true, "", "n or x=3"},
+	{false, "", "n and x=3"},
+	{true, "", "not n"},
+	{false, "\"noname\" is unknown", "noname is null"},
+	{true, "", "name ~ \"foobar\""},
+	{true, "", "name ~ \"fobar\""},
+	{true, "", "name ~ \"FOOBRA\""},
+	{true, "", "name ~ \"oba\""},
+	{false, "", "name ~ \"fxxbar\""},
+	{false, "", "name ~ \"oxa\""},
+	{true, "", "not name ~ \"baz\""},
+	{true, "", "name ~ \"\""},
+	{false, "", "n ~ \"foo\""},
+	{false, "1:1: cannot apply \"~\" to time and text", "now() ~ \"2024\""},
+	{true, "", "levenshtein(\"kitten\", \"sitting\") = 3"},
+	{true, "", "levenshtein(name, name) = 0"},
+	{true, "", "similarity(name, \"foobaz\") > 0.8"},
+	{false, "", "similarity(name, \"FOOBAR\") > 0.5"},
+	{true, "", "similarity(e, e) = 1"},
+	{false, "1:1: similarity() expects text as argument 2, got number", "similarity(name, 1) > 0"},
+	{true, "", "case when x = 3 then \"three\" else \"other\" end = \"three\""},
+	{true, "", "case when x > 5 then \"big\" when x > 2 then \"medium\" else \"small\" end = \"medium\""},
+	{true, "", "case x when 1 then \"one\" when 3 then \"three\" end = \"three\""},
+	{true, "", "case x when 1 then \"one\" end is null"},
+	{true, "", "case when n then 1 else 2 end = 2"},
+	{true, "", "case n when null then 1 else 2 end = 2"},
+	{true, "", "case when x > 1 then true else 1 / 0 end"},
+	{false, "division by zero", "case when x > 5 then true else 1 / 0 end"},
+	{true, "", "case when name like \"foo%\" then x > 1 else false end"},
+	{true, "", "iif(x = 3, \"a\", 1 / 0) = \"a\""},
+	{true, "", "IIF(x = 4, 1 / 0, \"b\") = \"b\""},
+	{false, "1:1: iif() expects 3 argument(s), got 2", "iif(x, 1)"},
+	{true, "", "x = 3 -- a comment"},
+	{true, "", "x = /* three */ 3"},
+	{true, "", "x = 3 /* a comment\nover two lines */ and\n-- and another one\nname = \"foobar\""},
+	{true, "", "\"--\" = concat(\"-\", \"-\") and \"/*\" like \"/%\""},
+	{true, "", "x - -1 = 4"},
+	{false, "", "x = 4 --or x = 3"},
 }
 
-func check(t *testing.T, w string, expect bool, errmsg string) string {
+func check(t *testing.T, w string, expect bool, errmsg string, opts ...Option) string {
 	test := func(name string) *Value {
 		switch name {
 		case "x":
@@ -52,6 +260,22 @@ func check(t *testing.T, w string, expect bool, errmsg string) string {
 			return NumberValue(40000)
 		case "name":
 			return TextValue("foobar")
+		case "e":
+			return TextValue("")
+		case "t":
+			return TimeValue(time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local))
+		case "d":
+			return TextValue("2024-05-10")
+		case "r":
+			return FloatValue(0.75)
+		case "n":
+			return NullValue()
+		case "c.name":
+			return TextValue("foo.zip")
+		case "l":
+			return TextListValue([]string{"src", "node_modules"})
+		case "el":
+			return ListValue()
 		default:
 			return nil
 		}
@@ -65,7 +289,7 @@ func check(t *testing.T, w string, expect bool, errmsg string) string {
 		}
 	}
 
-	if filter, err := CreateFilter(w); err == nil {
+	if filter, err := CreateFilter(w, opts...); err == nil {
 		if r, err := filter.Test(test); err == nil {
 			if errmsg != "" {
 				return "missing error: " + errmsg
@@ -82,6 +306,718 @@ func check(t *testing.T, w string, expect bool, errmsg string) string {
 	}
 }
 
+func TestRegisterFunc(t *testing.T) {
+	err := RegisterFunc("owner_team", Func{
+		Params: []Kind{KindText, KindNumber},
+		Result: KindText,
+		Call: func(args []*Value) (*Value, error) {
+			if *args[1].Number > 0 {
+				return TextValue("team-" + *args[0].Text), nil
+			}
+			return nil, errors.New("no team")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterFunc("not valid", Func{}); err == nil {
+		t.Error("missing error for invalid function name")
+	}
+	call := func(args []*Value) (*Value, error) { return nil, nil }
+	for _, name := range []string{"iif", "ANY", "all", "exists", "count", "lower", "Abs"} {
+		if err := RegisterFunc(name, Func{Call: call}); fmt.Sprint(err) != "cannot replace the built-in function \""+name+"\"" {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	err = RegisterFunc("maybe_size", Func{
+		Params: []Kind{KindText},
+		Result: KindNumber,
+		Call: func(args []*Value) (*Value, error) {
+			switch *args[0].Text {
+			case "none":
+				return nil, nil
+			case "text":
+				return TextValue("1K"), nil
+			}
+			return NumberValue(1), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ex := range []example{
+		{true, "", "owner_team(name, x)=\"team-foobar\""},
+		{true, "", "OWNER_TEAM(name, 1) like \"team-%\""},
+		{false, "no team", "owner_team(name, -1)=\"\""},
+		{false, "1:1: owner_team() expects 2 argument(s), got 1", "owner_team(name)"},
+		{false, "owner_team() expects number as argument 2, got text", "owner_team(name, name)"},
+		{false, "1:1: owner_team() expects text as argument 1, got number", "owner_team(1, 1)"},
+		{false, "1:1: cannot apply \"+\" to text and number", "owner_team(name, 1)+1=0"},
+		{true, "", "maybe_size(name) = 1"},
+		{true, "", "maybe_size(\"none\") is null"},
+		{false, "maybe_size() returned text, expected number", "maybe_size(\"text\") = 1"},
+	} {
+		if r := check(t, ex.w, ex.expected, ex.errmsg); r != "" {
+			t.Error(ex.w + ": " + r)
+		}
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC) // wednesday
+	for s, expected := range map[string]string{
+		"today":          "2024-05-15 00:00:00",
+		"Yesterday":      "2024-05-14 00:00:00",
+		"this_week":      "2024-05-13 00:00:00",
+		"last week":      "2024-05-06 00:00:00",
+		"this_month":     "2024-05-01 00:00:00",
+		"last_month":     "2024-04-01 00:00:00",
+		"this_year":      "2024-01-01 00:00:00",
+		"last_year":      "2023-01-01 00:00:00",
+		"3 days ago":     "2024-05-12 10:30:00",
+		"2 weeks ago":    "2024-05-01 10:30:00",
+		"1 month ago":    "2024-04-15 10:30:00",
+		"90 minutes ago": "2024-05-15 09:00:00",
+		"-36h":           "2024-05-13 22:30:00",
+		"-2d":            "2024-05-13 10:30:00",
+		"-1w":            "2024-05-08 10:30:00",
+		"-1y":            "2023-05-15 10:30:00",
+	} {
+		if r, ok := RelativeTime(s, now); !ok {
+			t.Errorf("%s: not resolved", s)
+		} else if r.Format(time.DateTime) != expected {
+			t.Errorf("%s: result=%s expected=%s", s, r.Format(time.DateTime), expected)
+		}
+	}
+	for _, s := range []string{"", "2024-01-01", "foo", "3 days", "-3x"} {
+		if _, ok := RelativeTime(s, now); ok {
+			t.Errorf("%s: should not be resolved", s)
+		}
+	}
+}
+
+func TestRelativeDates(t *testing.T) {
+	now := WithNow(time.Date(2024, 5, 15, 10, 30, 0, 0, time.Local))
+	for _, ex := range []example{
+		{true, "", "d < \"3 days ago\""},
+		{true, "", "d > \"1 week ago\""},
+		{true, "", "d = \"-5d\""},
+		{true, "", "d < \"this week\""},
+		{true, "", "d >= \"this_month\""},
+		{false, "", "d between \"last_month\" and \"-1w\""},
+		{true, "", "d between \"last_month\" and \"yesterday\""},
+		{false, "", "t > \"-36h\""},
+		{true, "", "t > \"last_year\""},
+		{true, "", "t < now() - interval 4 weeks"},
+		{true, "", "now() = \"2024-05-15 10:30\""},
+		{false, "", "name = \"today\""},
+	} {
+		if r := check(t, ex.w, ex.expected, ex.errmsg, now); r != "" {
+			t.Error(ex.w + ": " + r)
+		}
+	}
+
+	f, err := CreateFilter("now() > t", now)
+	if err != nil {
+		t.Fatal(err)
+	} else if s := f.Now().Format(timeFormat); s != "2024-05-15 10:30:00" {
+		t.Errorf("Now() = %s", s)
+	}
+}
+
+func TestThreeValuedLogic(t *testing.T) {
+	for _, ex := range []example{
+		{false, "", "n = 1"},
+		{false, "", "not (n = 1)"},
+		{false, "", "n not between 1 and 2"},
+		{false, "", "x not in (n, 4)"},
+		{false, "", "x not in (n, 3)"},
+		{true, "", "x in (n, 3)"},
+		{false, "", "n not like \"%\""},
+		{true, "", "n or x=3"},
+		{false, "", "n or x=5"},
+		{false, "", "not (n or x=5)"},
+		{false, "", "n and x=3"},
+		{false, "", "not (n and x=3)"},
+		{true, "", "not (n and x=5)"},
+		{false, "", "not n"},
+		{true, "", "n is null"},
+		{true, "", "not (n is not null)"},
+	} {
+		if r := check(t, ex.w, ex.expected, ex.errmsg, WithThreeValuedLogic()); r != "" {
+			t.Error(ex.w + ": " + r)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := WithSchema(Schema{
+		"x": KindNumber, "y": KindNumber, "name": KindText, "e": KindText,
+		"t": KindTime, "d": KindText, "r": KindNumber, "n": KindAny,
+		"c.name": KindText, "c.size": KindNumber, "l": KindList,
+	})
+	for _, ex := range []example{
+		{true, "", "name=\"foobar\" and x=3"},
+		{true, "", "t < \"2024-02-01\" and t - interval 1 day < t"},
+		{true, "", "n is null or n=1"},
+		{false, "1:1: \"nmae\" is unknown, did you mean \"name\"?", "nmae=\"x\""},
+		{false, "1:8: \"nam\" is unknown, did you mean \"name\"?", "x=1 or nam"},
+		{false, "1:1: \"quux\" is unknown", "quux=1"},
+		{true, "", "c.name like \"%.zip\""},
+		{true, "", "\"src\" in l and any(l) like \"s%\" and cardinality(l) > 1"},
+		{false, "1:1: any() expects list as argument 1, got text", "any(name) = \"a\""},
+		{false, "1:1: cardinality() expects list as argument 1, got text", "cardinality(name) > 1"},
+		{false, "1:1: cannot apply \"CONTAINS\" to text and number", "name contains 1"},
+		{false, "1:1: cannot apply \"LIKE\" to list and text", "l like \"s%\""},
+		{false, "1:1: cannot apply \"=\" to number and text", "c.size = name"},
+		{false, "1:1: \"c.nmae\" is unknown, did you mean \"c.name\"?", "c.nmae = name"},
+		{false, "1:8: \"foo.txt\" is unknown (text must be quoted, e.g. \"foo.txt\")", "name = foo.txt"},
+		{false, "1:7: \"nmae\" is unknown, did you mean \"name\"?", "lower(nmae)=\"x\""},
+		{false, "1:1: cannot apply \"=\" to number and text", "x=\"big\""},
+		{false, "1:8: cannot apply \"<\" to text and number", "x=5 or name < 1"},
+		{false, "1:1: cannot apply \"BETWEEN\" to number and text", "x between 1 and \"9\""},
+		{false, "1:1: cannot apply \"IN\" to text and number", "name in (\"a\", 1)"},
+		{false, "1:1: cannot apply \"+\" to text and number", "name+1=2"},
+		{false, "1:1: cannot apply \"-\" to text", "-name=1"},
+		{false, "1:1: cannot apply \"+\" to time and number", "t + 1 > t"},
+		{false, "1:1: cannot apply \"=\" to interval and number", "t - t = 0"},
+		{false, "1:1: lower() expects text as argument 1, got number", "lower(x)=\"3\""},
+		{false, "1:1: cannot apply \"CASE\" to number and text", "case x when \"a\" then 1 end = 1"},
+		{false, "1:1: cannot apply \"+\" to text and number", "case when x = 1 then \"a\" else \"b\" end + 1 = 2"},
+		{true, "", "case when x = 1 then 1 else null end + 1 is null"},
+	} {
+		if r := check(t, ex.w, ex.expected, ex.errmsg, schema); r != "" {
+			t.Error(ex.w + ": " + r)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	nfc, nfkc, fold := WithNormalization(norm.NFC), WithNormalization(norm.NFKC), WithCaseFolding()
+	getter := func(name string) *Value {
+		switch name {
+		case "name":
+			return TextValue("Cafe\u0301.txt") // NFD, as stored by macOS
+		case "street":
+			return TextValue("Straße")
+		case "file":
+			return TextValue("\ufb01le") // fi ligature
+		default:
+			return nil
+		}
+	}
+	for _, ex := range []struct {
+		w      string
+		opts   []Option
+		expect bool
+	}{
+		{"name = \"Café.txt\"", nil, false},
+		{"name = \"Café.txt\"", []Option{nfc}, true},
+		{"name != \"Café.txt\"", []Option{nfc}, false},
+		{"name = \"Cafe\u0301.txt\"", []Option{WithNormalization(norm.NFD)}, true},
+		{"name in (\"a\", \"Café.txt\")", []Option{nfc}, true},
+		{"name like \"Caf_.txt\"", nil, false},
+		{"name like \"Caf_.txt\"", []Option{nfc}, true},
+		{"name glob \"Caf?.*\"", []Option{nfc}, true},
+		{"name = \"CAFÉ.TXT\"", []Option{nfc}, false},
+		{"name = \"CAFÉ.TXT\"", []Option{nfc, fold}, true},
+		{"name in (\"café.txt\")", []Option{nfc, fold}, true},
+		{"name like \"CAF%\"", []Option{fold}, true},
+		{"name ilike \"CAFÉ%\"", []Option{nfc}, true},
+		{"street ilike \"STRASSE\"", nil, true},
+		{"street iglob \"STRA*E\"", nil, true},
+		{"street = \"STRASSE\"", []Option{fold}, true},
+		{"street = \"STRASSE\"", nil, false},
+		{"\"ΣΊΣΥΦΟΣ\" ilike \"σίσυφος\"", nil, true},
+		{"file = \"file\"", []Option{nfc}, false},
+		{"file = \"file\"", []Option{nfkc}, true},
+	} {
+		f, err := CreateFilter(ex.w, ex.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, err := f.Test(getter); err != nil {
+			t.Errorf("%s: %v", ex.w, err)
+		} else if r != ex.expect {
+			t.Errorf("%s (%d options): result=%t expected=%t", ex.w, len(ex.opts), r, ex.expect)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	getter := func(name string) *Value {
+		switch name {
+		case "ext":
+			return TextValue("png")
+		case "size":
+			return NumberValue(3)
+		default:
+			return NullValue()
+		}
+	}
+	for w, expected := range map[string]*Value{
+		"case when ext in ('jpg', 'png') then 'image' when ext = 'go' then 'code' else 'other' end": TextValue("image"),
+		"case ext when 'go' then 'code' end": NullValue(),
+		"iif(size > 2, size * 2, 0)":         NumberValue(6),
+		"ext like 'p%'":                      BoolValue(true),
+		"size / 2.0":                         FloatValue(1.5),
+		"[ext, size]":                        ListValue(TextValue("png"), NumberValue(3)),
+	} {
+		f, err := CreateFilter(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := f.Eval(getter)
+		if err != nil {
+			t.Errorf("%s: %v", w, err)
+		} else if v.Kind() != expected.Kind() || v.String() != expected.String() {
+			t.Errorf("%s: got %s (%s), expected %s (%s)", w, v, v.Kind(), expected, expected.Kind())
+		}
+	}
+}
+
+func TestSubquery(t *testing.T) {
+	entry := func(name string, size int64) VariableGetter {
+		return func(n string) *Value {
+			switch n {
+			case "name":
+				return TextValue(name)
+			case "size":
+				return NumberValue(size)
+			default:
+				return nil
+			}
+		}
+	}
+	reads := 0
+	getter := func(name string) *Value {
+		switch name {
+		case "name":
+			return TextValue("a.zip")
+		case "entries":
+			return RowsValue(func() ([]VariableGetter, error) {
+				reads++
+				return []VariableGetter{entry("setup.exe", 100), entry("LICENSE", 1), entry("readme.txt", 5)}, nil
+			})
+		case "none":
+			return NullValue()
+		case "bad":
+			return RowsValue(func() ([]VariableGetter, error) { return nil, errors.New("not a valid zip file") })
+		default:
+			return nil
+		}
+	}
+	for _, ex := range []example{
+		{true, "", "exists(entries where name like \"%.exe\")"},
+		{false, "", "exists(entries where name like \"%.dll\")"},
+		{true, "", "count(entries where size > 2) = 2"},
+		{true, "", "count_entries(where name = \"LICENSE\") = 1 and exists_entries(where size = 5)"},
+		{false, "", "exists(none where size > 0)"},
+		{true, "", "count(none where size > 0) is null"},
+		{true, "", "exists(entries where name = \"a.zip\") or name = \"a.zip\""},
+		{false, "\"x\" is unknown", "exists(entries where x = 1)"},
+		{false, "not a valid zip file", "exists(bad where size > 0)"},
+		{false, "exists() expects rows before WHERE, got text", "exists(name where size > 0)"},
+		{false, "1:1: lower() cannot be used with WHERE, use exists(x WHERE ...) or count(x WHERE ...)", "lower(entries where size > 0) = 1"},
+	} {
+		f, err := CreateFilter(ex.w)
+		if err == nil {
+			var r bool
+			if r, err = f.Test(getter); err == nil && r != ex.expected {
+				t.Errorf("%s: result=%t expected=%t", ex.w, r, ex.expected)
+			}
+		}
+		if (err != nil || ex.errmsg != "") && fmt.Sprint(err) != ex.errmsg {
+			t.Errorf("%s: Err %v vs %s", ex.w, err, ex.errmsg)
+		}
+	}
+
+	// the rows are not read if a cheaper operand decides the filter
+	reads = 0
+	f, _ := CreateFilter("exists(entries where size > 0) or name = \"a.zip\"")
+	if r, err := f.Test(getter); !r || err != nil || reads != 0 {
+		t.Errorf("got %t %v, %d reads", r, err, reads)
+	}
+	if s := f.String(); s != "exists(entries WHERE size > 0) OR name = \"a.zip\"" {
+		t.Errorf("string: %s", s)
+	}
+	if fields := fmt.Sprint(f.Fields()); fields != "[entries name]" {
+		t.Errorf("fields: %s", fields)
+	}
+
+	f, err := CreateFilter("exists(entries where nmae = 1)", WithSchema(Schema{"name": KindText, "entries": KindRows}))
+	if fmt.Sprint(err) != "1:22: \"nmae\" is unknown, did you mean \"name\"?" {
+		t.Errorf("schema: %v %v", f, err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	getter := func(name string) *Value {
+		switch name {
+		case "name":
+			return TextValue("foobar")
+		case "x":
+			return NumberValue(3)
+		default:
+			return nil
+		}
+	}
+	f, err := CreateFilter("name like :pattern and x between ? and ? or name = :pattern")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := fmt.Sprint(f.Params()); p != "[pattern 1 2]" {
+		t.Errorf("params: %s", p)
+	}
+	if _, err := f.Test(getter); fmt.Sprint(err) != "parameter \"pattern\" is not bound" {
+		t.Errorf("unbound: %v", err)
+	}
+
+	for _, ex := range []struct {
+		values map[string]*Value
+		expect bool
+		errmsg string
+	}{
+		{map[string]*Value{"pattern": TextValue("foo%"), "1": NumberValue(1), "2": NumberValue(5)}, true, ""},
+		{map[string]*Value{"pattern": TextValue("foo%"), "1": NumberValue(4), "2": NumberValue(5)}, false, ""},
+		{map[string]*Value{"pattern": TextValue("\" or 1=1 or \""), "1": NumberValue(1), "2": NumberValue(5)}, false, ""},
+		{map[string]*Value{"pattern": NullValue(), "1": NumberValue(1), "2": NumberValue(5)}, false, ""},
+		{map[string]*Value{"pattern": TextValue("foo%"), "1": NumberValue(1)}, false, "parameter \"2\" is not bound"},
+		{map[string]*Value{"pattern": TextValue("foo%"), "1": NumberValue(1), "2": NumberValue(5), "x": NumberValue(1)}, false, "unknown parameter \"x\""},
+	} {
+		b, err := f.Bind(ex.values)
+		if err != nil {
+			if fmt.Sprint(err) != ex.errmsg {
+				t.Errorf("%v: Err %v vs %s", ex.values, err, ex.errmsg)
+			}
+			continue
+		}
+		if r, err := b.Test(getter); err != nil {
+			t.Errorf("%s: %v", b, err)
+		} else if r != ex.expect {
+			t.Errorf("%s: result=%t expected=%t", b, r, ex.expect)
+		}
+	}
+
+	b, _ := f.Bind(map[string]*Value{"pattern": TextValue("a\"b"), "1": NumberValue(1), "2": FloatValue(2.5)})
+	if s := b.String(); s != "name LIKE \"a\\x22b\" AND x BETWEEN 1 AND 2.5 OR name = \"a\\x22b\"" {
+		t.Errorf("bound: %s", s)
+	}
+	if s := f.String(); s != "name LIKE :pattern AND x BETWEEN ? AND ? OR name = :pattern" {
+		t.Errorf("original changed: %s", s)
+	}
+
+	f, err = CreateFilter("x in :values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = f.Bind(map[string]*Value{"values": ListValue(NumberValue(2), NumberValue(3))})
+	if err != nil {
+		t.Fatal(err)
+	} else if s := b.String(); s != "x IN ([2, 3])" {
+		t.Errorf("bound list: %s", s)
+	} else if r, err := b.Test(getter); !r || err != nil {
+		t.Errorf("bound list: %t %v", r, err)
+	}
+
+	f, err = CreateFilter("x = :v", WithSchema(Schema{"x": KindNumber}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Bind(map[string]*Value{"v": TextValue("a")}); fmt.Sprint(err) != "1:1: cannot apply \"=\" to number and text" {
+		t.Errorf("schema: %v", err)
+	}
+
+	rows := RowsValue(func() ([]VariableGetter, error) { return nil, nil })
+	if b, err := f.Bind(map[string]*Value{"v": rows}); fmt.Sprint(err) != "parameter \"v\" cannot be bound to rows" {
+		t.Errorf("rows: %v %v", b, err)
+	}
+	if s := f.String(); s != "x = :v" {
+		t.Errorf("rows: %s", s)
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, ex := range []struct{ in, out string }{
+		{"name LIKE \"%.go\" and size>1K", "name LIKE \"%.go\" AND size > 1024"},
+		{"a or b and c", "a OR b AND c"},
+		{"(a or b) and c", "(a OR b) AND c"},
+		{"not (a = 1 or b <> 2)", "NOT (a = 1 OR b != 2)"},
+		{"not not a", "NOT NOT a"},
+		{"x - (y - 1) = (x - y) - 1", "x - (y - 1) = x - y - 1"},
+		{"-(x+1)*2 % 3", "-(x + 1) * 2 % 3"},
+		{"-(-size) = 100 and - -1 = x", "- -size = 100 AND - -1 = x"},
+		{"(a = 1) + 1", "(a = 1) + 1"},
+		{"Lower(name) not in ('a', 'say \"hi\"', \"\\\\\")", "lower(name) NOT IN (\"a\", \"say \\x22hi\\x22\", \"\\\\\")"},
+		{"mtime > now() - interval 36 hours", "mtime > now() - INTERVAL 36 hour"},
+		{"t + interval 1.5 minute < t - INTERVAL 2 weeks", "t + INTERVAL 90 second < t - INTERVAL 2 week"},
+		{"x is not null and 1.5e3 < r", "x IS NOT NULL AND 1500.0 < r"},
+		{"x not between 1 and 2+3", "x NOT BETWEEN 1 AND 2 + 3"},
+		{"name rlike concat(e, 'a') or true or null", "name RLIKE concat(e, \"a\") OR TRUE OR NULL"},
+		{"case x when 1 then 'a' when 2 then 'b' else 'c' end = 'a'", "CASE x WHEN 1 THEN \"a\" WHEN 2 THEN \"b\" ELSE \"c\" END = \"a\""},
+		{"case when a or b then c+1 end * 2", "CASE WHEN a OR b THEN c + 1 END * 2"},
+		{"IIF(a, b, c)", "iif(a, b, c)"},
+		{"name = :n or x in (?, ?)", "name = :n OR x IN (?, ?)"},
+		{"name~'x' or similarity(name, 'y') > 0.5", "name ~ \"x\" OR similarity(name, \"y\") > 0.5"},
+		{"name like '100!%' escape '!'", "name LIKE \"100!%\" ESCAPE \"!\""},
+		{"name not ilike '50\\%'", "name NOT ILIKE \"50\\\\%\""},
+		{"Count_Entries(where size > 1k) = 0 or exists(e where x)", "count(entries WHERE size > 1024) = 0 OR exists(e WHERE x)"},
+		{"x in [1, 2] or 'a' = ANY(l) and l not contains 'b' and cardinality([]) = 0", "x IN ([1, 2]) OR \"a\" = any(l) AND l NOT CONTAINS \"b\" AND cardinality([]) = 0"},
+		{"container.date < '2020' and parent . name = 'x'", "container.date < \"2020\" AND parent.name = \"x\""},
+	} {
+		f, err := Parse(ex.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := f.String(); s != ex.out {
+			t.Errorf("%s: got %s, expected %s", ex.in, s, ex.out)
+		} else if n, err := Parse(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if n.String() != s {
+			t.Errorf("%s: not canonical, got %s", s, n.String())
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	f, err := CreateFilter("name like \"a%\" and (size > x or lower(name) = ext) or not name ilike y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := fmt.Sprint(f.Fields()); fields != "[name size x ext y]" {
+		t.Errorf("Fields() = %s", fields)
+	}
+	var likes []string
+	Walk(f.AST(), func(n Node) bool {
+		if x, ok := n.(*Like); ok {
+			likes = append(likes, x.Op)
+			return false
+		}
+		return true
+	})
+	if fmt.Sprint(likes) != "[LIKE ILIKE]" {
+		t.Errorf("likes = %v", likes)
+	}
+	if n, ok := f.AST().(*Logical); !ok || n.Op != "OR" || len(n.Operands) != 2 {
+		t.Errorf("unexpected root %#v", f.AST())
+	} else if p := n.Operands[1].Pos(); p.Offset != 54 || p.Column != 55 {
+		t.Errorf("unexpected position %v", p)
+	}
+}
+
+func TestPatternError(t *testing.T) {
+	_, err := CreateFilter("x = 1 or\n  name rlike \"[z-a]\"")
+	var perr *PatternError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected PatternError, got %v", err)
+	}
+	if perr.Pattern != "[z-a]" || perr.Pos != (Position{Offset: 22, Line: 2, Column: 14}) || perr.End.Offset != 29 {
+		t.Errorf("unexpected %#v", perr)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	schema := WithSchema(Schema{"name": KindText, "size": KindNumber})
+	for _, ex := range []struct {
+		w        string
+		pos, end int
+	}{
+		{"size > 1 and\n  sizee < 2", 15, 20},
+		{"sizee -- typo\n  < 2", 0, 5},
+		{"size /* x */ = 'a'", 0, 18},
+		{"name = 'a' or size = 'b'", 14, 24},
+		{"lower(size) = 'a'", 0, 11},
+	} {
+		_, err := CreateFilter(ex.w, schema)
+		var cerr *CompileError
+		if !errors.As(err, &cerr) {
+			t.Errorf("%s: expected CompileError, got %v", ex.w, err)
+		} else if cerr.Pos.Offset != ex.pos || cerr.End.Offset != ex.end {
+			t.Errorf("%s: unexpected %#v", ex.w, cerr)
+		}
+	}
+}
+
+func TestDynamicPattern(t *testing.T) {
+	filter, err := CreateFilter("name like container or name rlike concat(\"\\\\.\", ext, \"$\")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ex := range []struct {
+		name, container, ext string
+		expect               bool
+	}{
+		{"a.zip", "a.%", "txt", true},
+		{"a.txt", "b.%", "txt", true},
+		{"a.txt", "b.%", "zip", false},
+		{"b.zip", "b.%", "zip", true},
+		{"a.txt", "b.%", "txt", true},
+		{"atxt", "b.%", "txt", false},
+	} {
+		r, err := filter.Test(func(name string) *Value {
+			switch name {
+			case "name":
+				return TextValue(ex.name)
+			case "container":
+				return TextValue(ex.container)
+			case "ext":
+				return TextValue(ex.ext)
+			default:
+				return nil
+			}
+		})
+		if err != nil {
+			t.Error(err)
+		} else if r != ex.expect {
+			t.Errorf("%v: result=%t", ex, r)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	filter, err := CreateFilter("name ~ \"reprot\" or similarity(name, \"summary.txt\") > 0.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ex := range []struct {
+		name   string
+		expect bool
+		score  float64
+	}{
+		{"report", true, 1 - 1/6.0},
+		{"report.pdf", true, (1 - 1/6.0) * (1 + 0.6) / 2},
+		{"REPROT.TXT", true, (1 + 0.6) / 2},
+		{"summary.txt", true, 1},
+		{"summary.tx", true, 1 - 1/11.0},
+		{"summary", false, 7 / 11.0},
+	} {
+		r, score, err := filter.Score(func(name string) *Value { return TextValue(ex.name) })
+		if err != nil {
+			t.Error(err)
+		} else if r != ex.expect || math.Abs(score-ex.score) > 1e-9 {
+			t.Errorf("%s: result=%t score=%f, expected %t %f", ex.name, r, score, ex.expect, ex.score)
+		}
+	}
+
+	filter, err = CreateFilter("name like \"%.txt\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, score, err := filter.Score(func(name string) *Value { return TextValue("a.txt") }); !r || score != 0 || err != nil {
+		t.Errorf("got %t %f %v", r, score, err)
+	}
+
+	// the fuzzy match is scored even though the cheaper LIKE decides the OR
+	filter, err = CreateFilter("name like \"%.txt\" or name ~ \"reprot.txt\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, score, err := filter.Score(func(name string) *Value { return TextValue("report.txt") }); !r || score != 0.9 || err != nil {
+		t.Errorf("got %t %f %v", r, score, err)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, ex := range []struct{ w, errmsg string }{
+		{"name like", "1:10: unexpected end of filter, expected a value"},
+		{"name lik \"a%\"", "1:6: unexpected \"lik\" (did you mean LIKE?)"},
+		{"name = my file.txt", "1:11: unexpected \"file\" (text must be quoted, e.g. \"my file.txt\")"},
+		{"name = \"abc", "1:8: unexpected '\"' (missing closing quote)"},
+		{"name == \"a\"", "1:7: unexpected \"=\", expected a value (use = to compare)"},
+		{"(x = 1", "1:7: unexpected end of filter, expected \")\" (missing closing parenthesis)"},
+		{"x = 1)", "1:6: unexpected \")\" (unbalanced parenthesis)"},
+		{"x = 1 && y = 2", "1:7: unexpected \"&&\" (use AND)"},
+		{"x ! 1", "1:3: unexpected \"!\" (use NOT or !=)"},
+		{"name in \"a\", \"b\"", "1:12: unexpected \",\" (the values after IN must be in parentheses, e.g. IN (\"a\", \"b\"))"},
+		{"x like *.go", "1:8: unexpected \"*\", expected a value (patterns must be quoted, e.g. \"%.go\")"},
+		{"t > now() - interval 1e300 days", "1:22: interval 1e300 days is out of range"},
+		{"t > now() - interval 2 months", "1:22: invalid interval unit \"months\" (use e.g. INTERVAL 30 day or \"1 month ago\")"},
+		{"mtime > now() - interval 3", "1:27: unexpected end of filter, expected a name (intervals need a unit, e.g. INTERVAL 3 day)"},
+		{"x = 1 and\n  y = 'ab' 'c'", "2:12: unexpected \"'c'\" (missing operator, AND or OR)"},
+		{"x = 1 or or y", "1:10: unexpected \"or\", expected a condition"},
+		{"case when then 1 end", "1:11: unexpected \"then\", expected a condition"},
+		{"case when x = 1 then 2 else", "1:28: unexpected end of filter, expected a value"},
+		{"case x end", "1:8: unexpected \"end\", expected \"WHEN\""},
+		{"case when x then 2 then 3 end", "1:20: unexpected \"then\", expected \"END\""},
+		{"x = 1 /* comment", "1:8: unexpected \"*\", expected a value (missing end of comment */)"},
+		{"x = 1 and /* comment", "1:11: unexpected \"/\", expected a condition (missing end of comment */)"},
+		{"exists(entries where)", "1:21: unexpected \")\", expected a condition"},
+		{"name in (:a :b)", "1:13: unexpected \":b\", expected \")\" (missing operator, AND or OR)"},
+	} {
+		_, err := CreateFilter(ex.w)
+		if fmt.Sprint(err) != ex.errmsg {
+			t.Errorf("%s: Err %v vs %s", ex.w, err, ex.errmsg)
+		}
+	}
+
+	_, err := CreateFilter("x = 'äö' zz")
+	var serr *SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected SyntaxError, got %v", err)
+	}
+	if serr.Pos != (Position{Offset: 11, Line: 1, Column: 10}) || serr.End.Offset != 13 || serr.Unexpected != "zz" {
+		t.Errorf("unexpected %#v", serr)
+	}
+
+	_, err = CreateFilter("t > interval 1e300 days + t")
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected SyntaxError, got %v", err)
+	}
+	if serr.Pos.Offset != 13 || serr.End.Offset != 23 {
+		t.Errorf("unexpected %#v", serr)
+	}
+
+	_, err = CreateFilter("name = café")
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected SyntaxError, got %v", err)
+	}
+	if serr.Unexpected != "é" || serr.Pos.Offset != 10 || serr.End != (Position{Offset: 12, Line: 1, Column: 12}) {
+		t.Errorf("unexpected %#v", serr)
+	}
+}
+
+// TestConcurrent shares each filter between goroutines, run with -race.
+func TestConcurrent(t *testing.T) {
+	for _, ex := range []struct {
+		w      string
+		expect func(i int) bool
+	}{
+		{"name like \"file1%\"", func(i int) bool { return i == 1 || i >= 10 && i < 20 }},
+		{"name ilike concat(\"FILE\", i)", func(i int) bool { return true }},
+		{"name rlike concat(\"^file\", i % 3, \"$\")", func(i int) bool { return i < 3 }},
+		{"i > 2 and (name like \"%5\" or i between 30 and 40)", func(i int) bool { return i%10 == 5 || i >= 30 && i <= 40 }},
+	} {
+		filter, err := CreateFilter(ex.w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				r, err := filter.Test(func(name string) *Value {
+					switch name {
+					case "i":
+						return NumberValue(int64(i))
+					case "name":
+						return TextValue(fmt.Sprintf("file%d", i))
+					default:
+						return nil
+					}
+				})
+				if err != nil {
+					t.Error(ex.w + ": " + err.Error())
+				} else if r != ex.expect(i) {
+					t.Errorf("%s: i=%d result=%t", ex.w, i, r)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
 func TestFilters(t *testing.T) {
 	for _, ex := range examples {
 		r := check(t, ex.w, ex.expected, ex.errmsg)
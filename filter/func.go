@@ -0,0 +1,265 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Func describes a function that can be called from a filter expression.
+type Func struct {
+	// Params is the kind of each parameter, KindAny accepts values of every kind.
+	Params []Kind
+	// Variadic specifies that the last parameter can be repeated.
+	Variadic bool
+	// Result is the kind of the value returned by Call.
+	Result Kind
+	// Call implements the function. The arguments are checked against Params
+	// before Call is invoked, note that a KindNumber argument is stored either in
+	// Number or in Float. A nil result is NULL, other results must match
+	// Result. Call must be safe for concurrent use.
+	Call func(args []*Value) (*Value, error)
+}
+
+type funcDef struct {
+	params   []Kind
+	optional int // number of trailing parameters that can be omitted
+	variadic bool
+	nullable bool // if false, the result is NULL when one of the arguments is NULL
+	pure     bool // the result only depends on the arguments
+	result   Kind
+	call     func(ctx context, args []*value) (*value, error)
+}
+
+var (
+	funcsMu sync.RWMutex
+	funcs   = map[string]*funcDef{
+		"lower":       {params: []Kind{KindText}, pure: true, result: KindText, call: textFunc(strings.ToLower)},
+		"upper":       {params: []Kind{KindText}, pure: true, result: KindText, call: textFunc(strings.ToUpper)},
+		"length":      {params: []Kind{KindText}, pure: true, result: KindNumber, call: funcLength},
+		"substr":      {params: []Kind{KindText, KindNumber, KindNumber}, optional: 1, pure: true, result: KindText, call: funcSubstr},
+		"replace":     {params: []Kind{KindText, KindText, KindText}, pure: true, result: KindText, call: funcReplace},
+		"concat":      {params: []Kind{KindAny}, variadic: true, pure: true, result: KindText, call: funcConcat},
+		"coalesce":    {params: []Kind{KindAny}, variadic: true, nullable: true, pure: true, result: KindAny, call: funcCoalesce},
+		"abs":         {params: []Kind{KindNumber}, pure: true, result: KindNumber, call: funcAbs},
+		"levenshtein": {params: []Kind{KindText, KindText}, pure: true, result: KindNumber, call: funcLevenshtein},
+		"similarity":  {params: []Kind{KindText, KindText}, pure: true, result: KindNumber, call: funcSimilarity},
+		"cardinality": {params: []Kind{KindList}, pure: true, result: KindNumber, call: funcCardinality},
+		"now":         {result: KindTime, call: funcNow},
+	}
+	funcNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// reserved are the built-in functions and the names that the compiler
+	// handles itself
+	reserved = map[string]bool{"iif": true, "any": true, "all": true, "exists": true, "count": true}
+)
+
+func init() {
+	for name := range funcs {
+		reserved[name] = true
+	}
+}
+
+// RegisterFunc makes a function available to all filters that are created
+// afterwards. Function names are case-insensitive, registering a name again
+// replaces the function. The built-in functions cannot be replaced.
+func RegisterFunc(name string, fn Func) error {
+	if !funcNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid function name \"%s\"", name)
+	}
+	if reserved[strings.ToLower(name)] {
+		return fmt.Errorf("cannot replace the built-in function \"%s\"", name)
+	}
+	if fn.Call == nil || (fn.Variadic && len(fn.Params) == 0) {
+		return fmt.Errorf("invalid definition for function \"%s\"", name)
+	}
+	def := &funcDef{
+		params:   fn.Params,
+		variadic: fn.Variadic,
+		result:   fn.Result,
+		call: func(_ context, args []*value) (*value, error) {
+			vargs := make([]*Value, len(args))
+			for i, a := range args {
+				vargs[i] = a.export()
+			}
+			r, err := fn.Call(vargs)
+			if err != nil {
+				return nil, err
+			} else if r == nil {
+				return nullValue(), nil
+			} else if k := r.Kind(); !fn.Result.accepts(k) {
+				return nil, fmt.Errorf("%s() returned %s, expected %s", strings.ToLower(name), k, fn.Result)
+			}
+			return r.tovalue(name)
+		},
+	}
+	funcsMu.Lock()
+	funcs[strings.ToLower(name)] = def
+	funcsMu.Unlock()
+	return nil
+}
+
+func textValue(s string) *value { return &value{Text: &s} }
+
+func numValue(n int64) *value { return &value{Number: &n} }
+
+func floatValue(f float64) *value { return &value{Float: &f} }
+
+func textFunc(fn func(string) string) func(context, []*value) (*value, error) {
+	return func(_ context, args []*value) (*value, error) {
+		return textValue(fn(*args[0].Text)), nil
+	}
+}
+
+func funcLength(_ context, args []*value) (*value, error) {
+	return numValue(int64(len([]rune(*args[0].Text)))), nil
+}
+
+// substr uses SQL semantics, start is 1-based and the length is optional
+func funcSubstr(_ context, args []*value) (*value, error) {
+	r := []rune(*args[0].Text)
+	start := args[1].int()
+	end := int64(len(r))
+	if len(args) > 2 {
+		end = start - 1 + args[2].int()
+	}
+	start = max(start-1, 0)
+	end = min(end, int64(len(r)))
+	if start >= end {
+		return textValue(""), nil
+	}
+	return textValue(string(r[start:end])), nil
+}
+
+func funcReplace(_ context, args []*value) (*value, error) {
+	return textValue(strings.ReplaceAll(*args[0].Text, *args[1].Text, *args[2].Text)), nil
+}
+
+func funcConcat(_ context, args []*value) (*value, error) {
+	var sb strings.Builder
+	for _, v := range args {
+		sb.WriteString(v.String())
+	}
+	return textValue(sb.String()), nil
+}
+
+// coalesce returns the first argument that is not NULL
+func funcCoalesce(_ context, args []*value) (*value, error) {
+	for _, v := range args {
+		if !v.isNull() {
+			return v, nil
+		}
+	}
+	return args[len(args)-1], nil
+}
+
+func funcAbs(_ context, args []*value) (*value, error) {
+	if args[0].Float != nil {
+		return floatValue(math.Abs(*args[0].Float)), nil
+	}
+	n := *args[0].Num()
+	if n == math.MinInt64 {
+		return nil, ErrOverflow
+	} else if n < 0 {
+		n = -n
+	}
+	return numValue(n), nil
+}
+
+func funcLevenshtein(_ context, args []*value) (*value, error) {
+	return numValue(int64(levenshtein(*args[0].Text, *args[1].Text))), nil
+}
+
+func funcSimilarity(ctx context, args []*value) (*value, error) {
+	s := similarity(*args[0].Text, *args[1].Text)
+	ctx.addScore(s)
+	return floatValue(s), nil
+}
+
+func funcCardinality(_ context, args []*value) (*value, error) {
+	return numValue(int64(len(args[0].List))), nil
+}
+
+func funcNow(ctx context, args []*value) (*value, error) {
+	return timeValue(ctx.now), nil
+}
+
+func (fn *funcDef) param(i int) Kind {
+	if i >= len(fn.params) {
+		return fn.params[len(fn.params)-1]
+	}
+	return fn.params[i]
+}
+
+func (fn *funcDef) argError(name string, i int, k Kind) error {
+	return fmt.Errorf("%s() expects %s as argument %d, got %s", name, fn.param(i), i+1, k)
+}
+
+// compileCall looks up the function and checks the number and kinds of the
+// arguments.
+func (c *compiler) compileCall(x *Call) (*node, error) {
+	name := strings.ToLower(x.Name)
+	if name == "iif" {
+		return c.compileIif(x)
+	} else if isQuantifier(x) {
+		return nil, fmt.Errorf("%s() must be the operand of a comparison, e.g. %s(x) = 1", name, strings.ToUpper(name))
+	}
+	funcsMu.RLock()
+	fn, ok := funcs[name]
+	funcsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown function \"%s\"", x.Name)
+	}
+	minArgs, maxArgs := len(fn.params)-fn.optional, len(fn.params)
+	if n := len(x.Args); n < minArgs || (!fn.variadic && n > maxArgs) {
+		expected := fmt.Sprintf("%d", minArgs)
+		switch {
+		case fn.variadic:
+			expected = fmt.Sprintf("at least %d", minArgs)
+		case maxArgs != minArgs:
+			expected = fmt.Sprintf("%d to %d", minArgs, maxArgs)
+		}
+		return nil, fmt.Errorf("%s() expects %s argument(s), got %d", name, expected, n)
+	}
+	args, err := c.compileAll(x.Args)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range args {
+		if !fn.param(i).accepts(a.kind) {
+			return nil, fn.argError(name, i, a.kind)
+		}
+	}
+
+	return &node{kind: fn.result, cost: totalCost(args...) + costCall, static: fn.pure && allStatic(args...), eval: func(ctx context) (*value, error) {
+		values := make([]*value, len(args))
+		for i, a := range args {
+			v, err := a.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if v.isNull() && !fn.nullable {
+				return v, nil
+			}
+			if !fn.param(i).accepts(v.kind()) {
+				return nil, fn.argError(name, i, v.kind())
+			}
+			values[i] = v
+		}
+		return fn.call(ctx, values)
+	}}, nil
+}
+
+// compileIif compiles iif(cond, a, b), which is not a regular function
+// because only the chosen argument is evaluated.
+func (c *compiler) compileIif(x *Call) (*node, error) {
+	if len(x.Args) != 3 {
+		return nil, fmt.Errorf("iif() expects 3 argument(s), got %d", len(x.Args))
+	}
+	args, err := c.compileAll(x.Args)
+	if err != nil {
+		return nil, err
+	}
+	return c.conditional(nil, args[:1], args[1:2], args[2]), nil
+}
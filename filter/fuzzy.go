@@ -0,0 +1,60 @@
+package filter
+
+import (
+	"slices"
+	"strings"
+)
+
+// fuzzyDistance returns the smallest number of edits (inserting, deleting or
+// substituting a character, or swapping two adjacent characters) that turn
+// pattern into a substring of text.
+func fuzzyDistance(text, pattern []rune) int {
+	d := make([][]int, len(pattern)+1)
+	for i := range d {
+		d[i] = make([]int, len(text)+1)
+		d[i][0] = i // the first row is 0, the match can start anywhere
+	}
+	for i := 1; i <= len(pattern); i++ {
+		for j := 1; j <= len(text); j++ {
+			cost := 1
+			if pattern[i-1] == text[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && pattern[i-1] == text[j-2] && pattern[i-2] == text[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return slices.Min(d[len(pattern)])
+}
+
+// fuzzyMatch implements the ~ operator. The comparison ignores case and
+// allows one edit for every four characters of the pattern. The score is 1 for
+// an exact match and decreases with the number of edits and the amount of
+// text around the match.
+func fuzzyMatch(text, pattern string) (bool, float64) {
+	t, p := []rune(strings.ToLower(text)), []rune(strings.ToLower(pattern))
+	if len(t) == 0 && len(p) == 0 {
+		return true, 1
+	} else if len(p) == 0 {
+		return true, 0.5
+	}
+	d := fuzzyDistance(t, p)
+	if d > len(p)/4 {
+		return false, 0
+	}
+	edits := 1 - float64(d)/float64(len(p))
+	coverage := float64(len(p)) / float64(max(len(p), len(t)))
+	return true, edits * (1 + coverage) / 2
+}
+
+// similarity returns 1 for equal texts and 0 for texts that have nothing in
+// common, based on the levenshtein distance.
+func similarity(a, b string) float64 {
+	n := max(len([]rune(a)), len([]rune(b)))
+	if n == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(n)
+}
@@ -0,0 +1,93 @@
+package filter
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// globToRegex converts a glob pattern to a regular expression. "*" and "?"
+// do not match "/", "**" matches across directories and "**/" also matches no
+// directory at all. "[...]" (or "[!...]") matches a character class and
+// "{a,b}" one of the alternatives. A backslash escapes the next character.
+func globToRegex(glob string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	depth := 0 // of {} braces
+	r := []rune(glob)
+	for i := 0; i < len(r); i++ {
+		switch c := r[i]; c {
+		case '*':
+			if i+1 < len(r) && r[i+1] == '*' {
+				i++
+				if i+1 < len(r) && r[i+1] == '/' {
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := i + 1
+			if end < len(r) && (r[end] == '!' || r[end] == '^') {
+				end++
+			}
+			if end < len(r) && r[end] == ']' {
+				end++ // a leading ] is part of the class
+			}
+			for end < len(r) && r[end] != ']' {
+				end++
+			}
+			if end >= len(r) {
+				return nil, errors.New("missing closing ]")
+			}
+			sb.WriteString("[")
+			j := i + 1
+			if r[j] == '!' || r[j] == '^' {
+				sb.WriteString("^")
+				j++
+			}
+			for ; j < end; j++ {
+				if r[j] == '\\' || r[j] == '[' || r[j] == ']' {
+					sb.WriteString(`\`)
+				}
+				sb.WriteRune(r[j])
+			}
+			sb.WriteString("]")
+			i = end
+		case '{':
+			depth++
+			sb.WriteString("(?:")
+		case ',':
+			if depth > 0 {
+				sb.WriteString("|")
+			} else {
+				sb.WriteString(",")
+			}
+		case '}':
+			if depth > 0 {
+				depth--
+				sb.WriteString(")")
+			} else {
+				sb.WriteString(`\}`)
+			}
+		case '\\':
+			if i+1 < len(r) {
+				i++
+				c = r[i]
+			}
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if depth > 0 {
+		return nil, errors.New("missing closing }")
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
@@ -2,7 +2,9 @@ package filter
 
 import (
 	"fmt"
-	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
@@ -19,48 +21,125 @@ type andCondition struct {
 }
 
 type condition struct {
+	Pos lexer.Position
+
 	Operand *conditionOperand `  @@`
 	Not     *condition        `| "NOT" @@`
 }
 
 type conditionOperand struct {
-	Operand      *term         `@@`
+	EndPos lexer.Position
+
+	Operand      *sum          `@@`
 	ConditionRHS *conditionRHS `@@?`
 }
 
 type conditionRHS struct {
-	Compare   *compare `  @@`
-	Not       bool     `| [ @"NOT" ] (`
-	Between   *between `      "BETWEEN" @@`
-	In        *in      `    | "IN" "(" @@ ")"`
-	Ilike     *term    `    | "ILIKE" @@`
-	Rlike     *term    `    | "RLIKE" @@`
-	Like      *term    `    | "LIKE" @@ )`
-	likeCache *regexp.Regexp
+	Compare  *compare `  @@`
+	Not      bool     `| [ @"NOT" ] (`
+	Between  *between `      "BETWEEN" @@`
+	In       *in      `    | "IN" @@`
+	Contains *sum     `    | "CONTAINS" @@`
+	Iglob    *sum     `    | "IGLOB" @@`
+	Glob     *sum     `    | "GLOB" @@`
+	Ilike    *like    `    | "ILIKE" @@`
+	Rlike    *sum     `    | "RLIKE" @@`
+	Like     *like    `    | "LIKE" @@ )`
+	IsNull   *isNull  `| @@`
+}
+
+type like struct {
+	Pattern *sum `@@`
+	Escape  *sum `( "ESCAPE" @@ )?`
+}
+
+type isNull struct {
+	Not  bool `"IS" @"NOT"?`
+	Null bool `@"NULL"`
 }
 
 type compare struct {
-	Operator string `@( "<>" | "<=" | ">=" | "=" | "<" | ">" | "!=" )`
-	Operand  *term  `@@`
+	Operator string `@( "<>" | "<=" | ">=" | "=" | "<" | ">" | "!=" | "~" )`
+	Operand  *sum   `@@`
 }
 
 type between struct {
-	Start *term `@@`
-	End   *term `"AND" @@`
+	Start *sum `@@`
+	End   *sum `"AND" @@`
 }
 
 type in struct {
-	Expressions []*term `@@ ( "," @@ )*`
+	Expressions []*sum `  "(" @@ ( "," @@ )* ")"`
+	List        *sum   `| @@`
+}
+
+type sum struct {
+	Left  *product     `@@`
+	Right []*opProduct `@@*`
+}
+
+type opProduct struct {
+	Operator string   `@( "+" | "-" )`
+	Operand  *product `@@`
+}
+
+type product struct {
+	Left  *term     `@@`
+	Right []*opTerm `@@*`
+}
+
+type opTerm struct {
+	Operator string `@( "*" | "/" | "%" )`
+	Operand  *term  `@@`
 }
 
 type term struct {
+	Pos    lexer.Position
+	EndPos lexer.Position
+
 	Value         *value      `  @@`
+	Case          *caseExpr   `| @@`
+	Call          *call       `| @@`
 	SymbolRef     *symbolRef  `| @@`
+	Param         *string     `| @Param`
+	List          *list       `| @@`
 	SubExpression *expression `| "(" @@ ")"`
+	Unary         *unary      `| @@`
+}
+
+type unary struct {
+	Operator string `@( "-" | "+" )`
+	Operand  *term  `@@`
+}
+
+type caseExpr struct {
+	Operand *sum        `"CASE" @@?`
+	Whens   []*when     `( "WHEN" @@ )+`
+	Else    *expression `( "ELSE" @@ )? "END"`
+}
+
+type when struct {
+	Cond   *expression `@@`
+	Result *expression `"THEN" @@`
+}
+
+type list struct {
+	Items []*expression `"[" ( @@ ( "," @@ )* )? "]"`
+}
+
+type call struct {
+	Name  string        `@Ident "("`
+	Where *where        `( @@`
+	Args  []*expression `  | @@ ( "," @@ )* )? ")"`
+}
+
+type where struct {
+	Source string      `@Ident? "WHERE"`
+	Cond   *expression `@@`
 }
 
 type symbolRef struct {
-	Symbol string `@Ident`
+	Symbol string `@Ident ( @"." @Ident )*`
 }
 
 type size int64
@@ -68,10 +147,16 @@ type size int64
 func (s *size) Capture(v []string) error { n, err := ParseSize(v[0]); *s = size(n); return err }
 
 type value struct {
-	Size    *size    ` ( @Size`
-	Number  *int64   ` | @Number`
-	Text    *string  ` | @Text`
-	Boolean *boolean ` | @("TRUE" | "FALSE") )`
+	Size     *size                            ` ( @Size`
+	Float    *float64                         ` | @Float`
+	Number   *int64                           ` | @Number`
+	Text     *string                          ` | @Text`
+	Boolean  *boolean                         ` | @("TRUE" | "FALSE")`
+	Null     bool                             ` | @"NULL"`
+	Interval *interval                        ` | "INTERVAL" @((Number | Float) Ident) )`
+	Time     *time.Time                       // only set during evaluation
+	List     []*value                         // only set during evaluation
+	Rows     func() ([]VariableGetter, error) // only set during evaluation
 }
 
 func boolValue(v bool) *value {
@@ -88,27 +173,107 @@ func (v value) Num() *int64 {
 	return v.Number
 }
 
+func nullValue() *value { return &value{} }
+
+func (v value) isNull() bool { return v.kind() == KindNull }
+
+func (v value) isNum() bool { return v.Num() != nil || v.Float != nil }
+
+// float returns a number as float64, integers are converted.
+func (v value) float() float64 {
+	if v.Float != nil {
+		return *v.Float
+	}
+	return float64(*v.Num())
+}
+
+// int returns a number as int64, floats are truncated.
+func (v value) int() int64 {
+	if n := v.Num(); n != nil {
+		return *n
+	}
+	return int64(*v.Float)
+}
+
 func (v value) String() string {
 	switch {
 	case v.Num() != nil:
 		return fmt.Sprintf("%d", *v.Num())
+	case v.Float != nil:
+		return strconv.FormatFloat(*v.Float, 'g', -1, 64)
 	case v.Text != nil:
 		return *v.Text
 	case v.Boolean != nil:
 		return fmt.Sprintf("%t", *v.Boolean)
+	case v.Time != nil:
+		return v.Time.Format(timeFormat)
+	case v.Interval != nil:
+		return time.Duration(*v.Interval).String()
+	case v.List != nil, v.Rows != nil:
+		return v.export().String()
 	default:
 		return "(empty)"
 	}
 }
 
+func (v value) kind() Kind {
+	switch {
+	case v.isNum():
+		return KindNumber
+	case v.Text != nil:
+		return KindText
+	case v.Boolean != nil:
+		return KindBool
+	case v.Time != nil:
+		return KindTime
+	case v.Interval != nil:
+		return KindInterval
+	case v.List != nil:
+		return KindList
+	case v.Rows != nil:
+		return KindRows
+	default:
+		return KindNull
+	}
+}
+
+func (v value) export() *Value {
+	r := &Value{
+		Number:   v.Num(),
+		Float:    v.Float,
+		Text:     v.Text,
+		Boolean:  (*bool)(v.Boolean),
+		Time:     v.Time,
+		Interval: (*time.Duration)(v.Interval),
+		Rows:     v.Rows,
+	}
+	if v.List != nil {
+		r.List = make([]*Value, len(v.List))
+		for i, item := range v.List {
+			r.List[i] = item.export()
+		}
+	}
+	return r
+}
+
 func (x *value) Bool() bool {
 	switch {
 	case x.Num() != nil:
 		return *x.Num() != 0
+	case x.Float != nil:
+		return *x.Float != 0
 	case x.Text != nil:
 		return *x.Text != ""
 	case x.Boolean != nil:
 		return bool(*x.Boolean)
+	case x.Time != nil:
+		return !x.Time.IsZero()
+	case x.Interval != nil:
+		return *x.Interval != 0
+	case x.List != nil:
+		return len(x.List) > 0
+	case x.Rows != nil:
+		return true
 	default:
 		return false
 	}
@@ -116,17 +281,182 @@ func (x *value) Bool() bool {
 
 var (
 	exprLexer = lexer.MustSimple([]lexer.SimpleRule{
-		{`Keyword`, `(?i)\b(TRUE|FALSE|NOT|BETWEEN|AND|OR|LIKE|ILIKE|RLIKE|IN)\b`},
+		{`Keyword`, `(?i)\b(TRUE|FALSE|NOT|BETWEEN|AND|OR|LIKE|ILIKE|RLIKE|ESCAPE|GLOB|IGLOB|IN|CONTAINS|INTERVAL|WHERE|IS|NULL|CASE|WHEN|THEN|ELSE|END)\b`},
 		{`Ident`, `[a-zA-Z_][a-zA-Z0-9_]*`},
 		{`Size`, `\d*\.?\d+[BKMGTbkmgt]`},
-		{`Number`, `[-+]?\d*\.?\d+([eE][-+]?\d+)?`},
+		{`Float`, `\d*\.\d+([eE][-+]?\d+)?|\d+[eE][-+]?\d+`},
+		{`Number`, `\d+`},
 		{`Text`, `'[^']*'|"[^"]*"`},
-		{`Operators`, `<>|!=|<=|>=|[,.()=<>]`},
+		{`Param`, `:[a-zA-Z_][a-zA-Z0-9_]*|\?`},
+		{"comment", `--[^\n]*|/\*(?s:.*?)\*/`},
+		{`Operators`, `<>|!=|<=|>=|[-+*/%,.()\[\]=<>~]`},
 		{"whitespace", `\s+`},
 	})
 	parser = participle.MustBuild[expression](
 		participle.Lexer(exprLexer),
-		participle.Unquote("Text"),
+		participle.Map(unquoteText, "Text"),
 		participle.CaseInsensitive("Keyword"),
 	)
 )
+
+// unquoteText removes the quotes from a Text token and resolves escape
+// sequences like \n or \\. A backslash that does not start a valid escape
+// sequence is kept, so patterns like "\." or "100\%" can be written as is.
+func unquoteText(tok lexer.Token) (lexer.Token, error) {
+	quote, s := tok.Value[0], tok.Value[1:len(tok.Value)-1]
+	var sb strings.Builder
+	for s != "" {
+		r, _, tail, err := strconv.UnquoteChar(s, quote)
+		if err != nil {
+			sb.WriteByte(s[0])
+			s = s[1:]
+			continue
+		}
+		sb.WriteRune(r)
+		s = tail
+	}
+	tok.Value = sb.String()
+	return tok, nil
+}
+
+// spanOf returns the span from the start of a to the end of b.
+func spanOf(a, b Node) span { return span{a.Pos(), b.End()} }
+
+func logical(op string, operands []Node) Node {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	return &Logical{span: spanOf(operands[0], operands[len(operands)-1]), Op: op, Operands: operands}
+}
+
+func astAll[T interface{ ast() Node }](items []T) []Node {
+	nodes := make([]Node, len(items))
+	for i, item := range items {
+		nodes[i] = item.ast()
+	}
+	return nodes
+}
+
+// ast converts the parsed grammar to the syntax tree.
+func (x *expression) ast() Node { return logical("OR", astAll(x.Or)) }
+
+func (x *andCondition) ast() Node { return logical("AND", astAll(x.And)) }
+
+func (x *condition) ast() Node {
+	if x.Operand != nil {
+		return x.Operand.ast()
+	}
+	n := x.Not.ast()
+	return &Not{span: span{position(x.Pos), n.End()}, Operand: n}
+}
+
+func (x *conditionOperand) ast() Node {
+	lhs := x.Operand.ast()
+	if x.ConditionRHS == nil {
+		return lhs
+	}
+	s := span{lhs.Pos(), position(x.EndPos)}
+	switch r := x.ConditionRHS; {
+	case r.Compare != nil:
+		op := r.Compare.Operator
+		if op == "<>" {
+			op = "!="
+		}
+		return &Compare{span: s, Op: op, Left: lhs, Right: r.Compare.Operand.ast()}
+	case r.Between != nil:
+		return &Between{span: s, Not: r.Not, Operand: lhs, Low: r.Between.Start.ast(), High: r.Between.End.ast()}
+	case r.In != nil:
+		if r.In.List != nil {
+			// x IN list is written as x IN (list)
+			return &In{span: s, Not: r.Not, Operand: lhs, List: []Node{r.In.List.ast()}}
+		}
+		return &In{span: s, Not: r.Not, Operand: lhs, List: astAll(r.In.Expressions)}
+	case r.Contains != nil:
+		return &Contains{span: s, Not: r.Not, Operand: lhs, Value: r.Contains.ast()}
+	case r.IsNull != nil:
+		return &IsNull{span: s, Not: r.IsNull.Not, Operand: lhs}
+	case r.Glob != nil:
+		return &Like{span: s, Op: "GLOB", Not: r.Not, Operand: lhs, Pattern: r.Glob.ast()}
+	case r.Iglob != nil:
+		return &Like{span: s, Op: "IGLOB", Not: r.Not, Operand: lhs, Pattern: r.Iglob.ast()}
+	case r.Ilike != nil:
+		return r.Ilike.ast(s, "ILIKE", r.Not, lhs)
+	case r.Rlike != nil:
+		return &Like{span: s, Op: "RLIKE", Not: r.Not, Operand: lhs, Pattern: r.Rlike.ast()}
+	default:
+		return r.Like.ast(s, "LIKE", r.Not, lhs)
+	}
+}
+
+func (x *like) ast(s span, op string, not bool, lhs Node) Node {
+	n := &Like{span: s, Op: op, Not: not, Operand: lhs, Pattern: x.Pattern.ast()}
+	if x.Escape != nil {
+		n.Escape = x.Escape.ast()
+	}
+	return n
+}
+
+func (x *sum) ast() Node {
+	n := x.Left.ast()
+	for _, o := range x.Right {
+		r := o.Operand.ast()
+		n = &Arith{span: spanOf(n, r), Op: o.Operator, Left: n, Right: r}
+	}
+	return n
+}
+
+func (x *product) ast() Node {
+	n := x.Left.ast()
+	for _, o := range x.Right {
+		r := o.Operand.ast()
+		n = &Arith{span: spanOf(n, r), Op: o.Operator, Left: n, Right: r}
+	}
+	return n
+}
+
+func (x *term) ast() Node {
+	s := span{position(x.Pos), position(x.EndPos)}
+	switch {
+	case x.Value != nil:
+		return &Literal{span: s, Value: x.Value.export()}
+	case x.Case != nil:
+		n := &Case{span: s}
+		if x.Case.Operand != nil {
+			n.Operand = x.Case.Operand.ast()
+		}
+		for _, w := range x.Case.Whens {
+			n.Whens = append(n.Whens, When{Cond: w.Cond.ast(), Result: w.Result.ast()})
+		}
+		if x.Case.Else != nil {
+			n.Else = x.Case.Else.ast()
+		}
+		return n
+	case x.Call != nil && x.Call.Where != nil:
+		return x.Call.Where.ast(s, x.Call.Name)
+	case x.Call != nil:
+		return &Call{span: s, Name: x.Call.Name, Args: astAll(x.Call.Args)}
+	case x.SymbolRef != nil:
+		return &Ident{span: s, Name: x.SymbolRef.Symbol}
+	case x.List != nil:
+		return &List{span: s, Items: astAll(x.List.Items)}
+	case x.Param != nil:
+		if *x.Param == "?" {
+			return &Param{span: s} // numbered by Parse
+		}
+		return &Param{span: s, Name: strings.TrimPrefix(*x.Param, ":")}
+	case x.Unary != nil:
+		return &Unary{span: s, Op: x.Unary.Operator, Operand: x.Unary.Operand.ast()}
+	default:
+		return x.SubExpression.ast()
+	}
+}
+
+// ast converts name(source WHERE cond) to a Subquery, count_x(WHERE cond) is
+// short for count(x WHERE cond).
+func (x *where) ast(s span, name string) Node {
+	n := &Subquery{span: s, Func: strings.ToLower(name), Source: x.Source, Where: x.Cond.ast()}
+	if f, source, ok := strings.Cut(n.Func, "_"); ok && n.Source == "" && (f == "exists" || f == "count") {
+		n.Func, n.Source = f, source
+	}
+	return n
+}
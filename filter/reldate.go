@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	relAgoRegex   = regexp.MustCompile(`^(\d+)\s*(second|minute|hour|day|week|month|year)s?\s+ago$`)
+	relShortRegex = regexp.MustCompile(`^([-+]\d+)([dwy])$`)
+)
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func startOfWeek(t time.Time) time.Time {
+	offs := (int(t.Weekday()) + 6) % 7 // weeks start on monday
+	return startOfDay(t).AddDate(0, 0, -offs)
+}
+
+// RelativeTime resolves a relative date against now. It accepts the names
+// "today", "yesterday", "this_week", "last_week", "this_month", "last_month",
+// "this_year" and "last_year" (which resolve to the start of the period),
+// phrases like "3 days ago" or "2 weeks ago" and offsets like "-36h", "-2d",
+// "-1w" or "-1y". ok is false if s is not a relative date.
+func RelativeTime(s string, now time.Time) (t time.Time, ok bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch strings.ReplaceAll(s, " ", "_") {
+	case "now":
+		return now, true
+	case "today":
+		return startOfDay(now), true
+	case "yesterday":
+		return startOfDay(now).AddDate(0, 0, -1), true
+	case "this_week":
+		return startOfWeek(now), true
+	case "last_week":
+		return startOfWeek(now).AddDate(0, 0, -7), true
+	case "this_month":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()), true
+	case "last_month":
+		return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, now.Location()), true
+	case "this_year":
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location()), true
+	case "last_year":
+		return time.Date(now.Year()-1, 1, 1, 0, 0, 0, 0, now.Location()), true
+	}
+
+	if m := relAgoRegex.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, false
+		}
+		switch m[2] {
+		case "day":
+			return now.AddDate(0, 0, -n), true
+		case "week":
+			return now.AddDate(0, 0, -7*n), true
+		case "month":
+			return now.AddDate(0, -n, 0), true
+		case "year":
+			return now.AddDate(-n, 0, 0), true
+		default:
+			return now.Add(-time.Duration(n) * intervalUnits[m[2]]), true
+		}
+	}
+
+	if m := relShortRegex.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, false
+		}
+		switch m[2] {
+		case "d":
+			return now.AddDate(0, 0, n), true
+		case "w":
+			return now.AddDate(0, 0, 7*n), true
+		default:
+			return now.AddDate(n, 0, 0), true
+		}
+	}
+
+	if len(s) > 1 && (s[0] == '-' || s[0] == '+') {
+		if d, err := time.ParseDuration(s); err == nil {
+			return now.Add(d), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// isDateText checks if s starts like a date in YYYY-MM-DD format.
+func isDateText(s string) bool {
+	if len(s) < 4 {
+		return false
+	}
+	for _, c := range s[:4] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) == 4 || s[4] == '-'
+}
+
+// resolveDateText replaces rel with the date it refers to if it is relative and
+// other is a date. The result has the same precision as other so both can be
+// compared as text.
+func (ctx context) resolveDateText(other, rel string) string {
+	if !isDateText(other) {
+		return rel
+	}
+	if t, ok := RelativeTime(rel, ctx.now); ok {
+		s := t.Format(time.DateTime)
+		return s[:min(len(other), len(s))]
+	}
+	return rel
+}
@@ -0,0 +1,73 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema maps the names of the variables that a filter can use to their kind.
+// Use KindAny for variables whose kind is only known during evaluation.
+type Schema map[string]Kind
+
+// WithSchema makes CreateFilter check the filter against a schema. References
+// to unknown variables and operations on incompatible kinds are reported
+// before the filter is evaluated. Names are matched case-insensitively.
+func WithSchema(s Schema) Option {
+	return func(o *options) { o.schema = s }
+}
+
+func (s Schema) lookup(name string) (Kind, bool) {
+	if k, ok := s[name]; ok {
+		return k, true
+	}
+	for n, k := range s {
+		if strings.EqualFold(n, name) {
+			return k, true
+		}
+	}
+	return KindAny, false
+}
+
+// unknownError reports an unknown variable and suggests the most similar name
+// from the schema.
+func (s Schema) unknownError(name string) error {
+	best, bestDist := "", 0
+	for n := range s {
+		d := levenshtein(strings.ToLower(name), strings.ToLower(n))
+		if best == "" || d < bestDist || (d == bestDist && n < best) {
+			best, bestDist = n, d
+		}
+	}
+	if best == "" || bestDist > max(2, utf8.RuneCountInString(name)/3) {
+		if strings.Contains(name, ".") {
+			// probably a file name, e.g. name = foo.txt
+			return fmt.Errorf("\"%s\" is unknown (text must be quoted, e.g. \"%s\")", name, name)
+		}
+		return fmt.Errorf("\"%s\" is unknown", name)
+	}
+	return fmt.Errorf("\"%s\" is unknown, did you mean \"%s\"?", name, best)
+}
+
+// levenshtein returns the number of single character edits that are needed
+// to change a into b.
+func levenshtein(a, b string) int {
+	r1, r2 := []rune(a), []rune(b)
+	prev := make([]int, len(r2)+1)
+	cur := make([]int, len(r2)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range r1 {
+		cur[0] = i + 1
+		for j := range r2 {
+			cost := 1
+			if r1[i] == r2[j] {
+				cost = 0
+			}
+			cur[j+1] = min(prev[j+1]+1, cur[j]+1, prev[j]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(r2)]
+}
@@ -0,0 +1,292 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// SyntaxError is returned when a filter cannot be parsed. Pos and End enclose
+// the unexpected token.
+type SyntaxError struct {
+	Pos        Position
+	End        Position
+	Unexpected string // the unexpected token, empty at the end of the filter
+	Expected   string // what was expected instead, may be empty
+	Hint       string // a suggestion how to fix the filter, may be empty
+	// Message describes an invalid value instead of Unexpected and Expected,
+	// e.g. an INTERVAL that is out of range
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	msg := fmt.Sprintf("%d:%d: unexpected ", e.Pos.Line, e.Pos.Column)
+	switch {
+	case e.Message != "":
+		msg = fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Column, e.Message)
+	case e.Unexpected == "":
+		msg += "end of filter"
+	case strings.Contains(e.Unexpected, "\""):
+		msg += "'" + e.Unexpected + "'"
+	default:
+		msg += "\"" + e.Unexpected + "\""
+	}
+	if e.Expected != "" {
+		msg += ", expected " + e.Expected
+	}
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+var (
+	expectedRegex = regexp.MustCompile(`\(expected (.+)\)$`)
+	keywords      = []string{"AND", "OR", "NOT", "LIKE", "ILIKE", "RLIKE", "ESCAPE", "GLOB", "IGLOB", "BETWEEN", "IN", "CONTAINS", "IS", "NULL", "WHERE", "TRUE", "FALSE", "INTERVAL", "CASE", "WHEN", "THEN", "ELSE", "END"}
+)
+
+// expectedNames translates the names of the grammar rules that participle
+// reports to something a user understands.
+var expectedNames = map[string]string{
+	"Expression":       "a condition",
+	"AndCondition":     "a condition",
+	"Condition":        "a condition",
+	"ConditionOperand": "a condition",
+	"Sum":              "a value",
+	"Product":          "a value",
+	"Term":             "a value",
+	"In":               "a value",
+	"When":             "\"WHEN\"",
+	"Like":             "a value",
+	"<ident>":          "a name",
+}
+
+// syntaxError converts the errors of the lexer and parser to a SyntaxError.
+func syntaxError(filter string, err error) error {
+	var lerr *lexer.Error
+	var uerr *participle.UnexpectedTokenError
+	var perr participle.Error
+	switch {
+	case errors.As(err, &lerr):
+		pos := position(lerr.Pos)
+		text := filter[min(pos.Offset, len(filter)):]
+		_, size := utf8.DecodeRuneInString(text)
+		e := &SyntaxError{Pos: pos, Unexpected: text[:size]}
+		switch {
+		case strings.HasPrefix(text, "&&"):
+			e.Unexpected, e.Hint = "&&", "use AND"
+		case strings.HasPrefix(text, "||"):
+			e.Unexpected, e.Hint = "||", "use OR"
+		case strings.HasPrefix(text, "!"):
+			e.Hint = "use NOT or !="
+		case strings.HasPrefix(text, "\""), strings.HasPrefix(text, "'"):
+			e.Hint = "missing closing quote"
+		}
+		e.End = advance(pos, e.Unexpected)
+		return e
+
+	case errors.As(err, &uerr):
+		tok := uerr.Unexpected
+		e := &SyntaxError{Pos: position(tok.Pos)}
+		if !tok.EOF() {
+			// use the text of the token before it was unquoted
+			if e.Unexpected = rawToken(filter[e.Pos.Offset:]); e.Unexpected == "" {
+				e.Unexpected = tok.Value
+			}
+		}
+		if m := expectedRegex.FindStringSubmatch(uerr.Message()); m != nil {
+			e.Expected = expected(m[1])
+		}
+		if what, ok := afterKeyword[strings.ToUpper(tok.Value)]; ok && missingAfter(filter, tok, e.Expected) {
+			// the keyword is there but the part that follows it is missing
+			if tok = nextToken(filter, tok); tok.EOF() {
+				e.Unexpected = ""
+			} else if e.Unexpected = rawToken(filter[tok.Pos.Offset:]); e.Unexpected == "" {
+				e.Unexpected = tok.Value
+			}
+			e.Pos, e.Expected = position(tok.Pos), what
+		}
+		e.Hint = hint(filter, tok, e.Unexpected, e.Expected)
+		e.End = advance(e.Pos, e.Unexpected)
+		return e
+
+	case errors.As(err, &perr) && strings.HasPrefix(perr.Message(), captureError):
+		// a value like a size or an interval is invalid
+		pos := position(perr.Position())
+		return &SyntaxError{Pos: pos, End: valueEnd(filter, pos), Message: strings.TrimPrefix(perr.Message(), captureError)}
+	}
+	return err
+}
+
+// captureError is the prefix of the errors of the Capture methods.
+const captureError = "failed to capture: "
+
+// valueEnd returns the end of the value that starts at pos, including the
+// unit of an interval.
+func valueEnd(filter string, pos Position) Position {
+	toks, _ := lexer.ConsumeAll(mustLex(filter[pos.Offset:]))
+	if len(toks) < 2 {
+		return pos
+	}
+	last := toks[0]
+	if isWord(toks[1].Value) && !isKeyword(toks[1].Value) {
+		last = toks[1]
+	}
+	return offsetPosition(filter, pos.Offset+last.Pos.Offset+len(last.Value))
+}
+
+// afterKeyword describes what must follow a keyword that the parser reports
+// as missing when the part after it is missing.
+var afterKeyword = map[string]string{
+	"WHEN": "a condition",
+	"THEN": "a value",
+	"ELSE": "a value",
+}
+
+// missingAfter checks if the parser rejected the keyword tok because the
+// part after it is missing. It then either expects the keyword itself, or
+// another keyword of CASE and the keyword is followed by one.
+func missingAfter(filter string, tok lexer.Token, expected string) bool {
+	expected = strings.Trim(expected, "\"")
+	if strings.EqualFold(expected, tok.Value) {
+		return true
+	}
+	next := nextToken(filter, tok)
+	return isCaseKeyword(expected) && (next.EOF() || isCaseKeyword(next.Value))
+}
+
+func isCaseKeyword(s string) bool {
+	s = strings.ToUpper(s)
+	return s == "WHEN" || s == "THEN" || s == "ELSE" || s == "END"
+}
+
+// nextToken returns the token that follows tok.
+func nextToken(filter string, tok lexer.Token) lexer.Token {
+	toks, _ := lexer.ConsumeAll(mustLex(filter))
+	for i, t := range toks {
+		if t.Pos.Offset == tok.Pos.Offset && i+1 < len(toks) {
+			return toks[i+1]
+		}
+	}
+	return lexer.EOFToken(tok.Pos)
+}
+
+// expected returns a description of the first element of a grammar sequence.
+func expected(s string) string {
+	first := strings.Trim(strings.Fields(s)[0], "(+*?")
+	if name, ok := expectedNames[first]; ok {
+		return name
+	}
+	return first
+}
+
+// rawToken returns the first token of s as it was written.
+func rawToken(s string) string {
+	l := mustLex(s)
+	tok, err := l.Next()
+	if err != nil || tok.EOF() {
+		return ""
+	}
+	next, err := l.Next()
+	if err != nil || next.EOF() {
+		return strings.TrimRight(s, " \t\r\n")
+	}
+	return strings.TrimRight(s[:next.Pos.Offset], " \t\r\n")
+}
+
+func mustLex(s string) lexer.Lexer {
+	l, _ := exprLexer.LexString("", s)
+	return l
+}
+
+// advance returns the position after text, which starts at pos and does not
+// contain a newline.
+func advance(pos Position, text string) Position {
+	pos.Offset += len(text)
+	pos.Column += len([]rune(text))
+	return pos
+}
+
+// hint guesses what the user wanted to write, raw is the unexpected token as
+// it was written.
+func hint(filter string, tok lexer.Token, raw, expected string) string {
+	prev := previousToken(filter, tok.Pos.Offset)
+	if off := tok.Pos.Offset; strings.HasPrefix(filter[min(off, len(filter)):], "/*") ||
+		(tok.Value == "*" && off > 0 && filter[off-1] == '/') {
+		return "missing end of comment */"
+	}
+	word := strings.ToUpper(tok.Value)
+	if isWord(word) && !isKeyword(word) {
+		best, bestDist := "", 0
+		for _, k := range keywords {
+			if d := levenshtein(word, k); best == "" || d < bestDist {
+				best, bestDist = k, d
+			}
+		}
+		if bestDist <= max(1, len(word)/3) {
+			return fmt.Sprintf("did you mean %s?", best)
+		}
+	}
+	switch {
+	case tok.EOF() && expected == "\")\"":
+		return "missing closing parenthesis"
+	case tok.Value == "," && strings.EqualFold(tokenBefore(filter, tok.Pos.Offset, 2), "IN"):
+		return "the values after IN must be in parentheses, e.g. IN (\"a\", \"b\")"
+	case tok.Value == "=" && strings.HasSuffix(prev, "="):
+		return "use = to compare"
+	case tok.Value == ")" && !isKeyword(prev):
+		return "unbalanced parenthesis"
+	case !tok.EOF() && isWord(prev) && !isKeyword(prev) && ((isWord(tok.Value) && !isKeyword(tok.Value)) || tok.Value == "."):
+		return "text must be quoted, e.g. \"my file.txt\""
+	case !tok.EOF() && expected == "a value" && isLikeKeyword(prev):
+		return "patterns must be quoted, e.g. \"%.go\""
+	case expected == "a name" && isNumber(prev):
+		return "intervals need a unit, e.g. INTERVAL 3 day"
+	case isValue(prev) && isValue(raw):
+		return "missing operator, AND or OR"
+	}
+	return ""
+}
+
+// previousToken returns the text of the token that ends before offset.
+func previousToken(filter string, offset int) string { return tokenBefore(filter, offset, 1) }
+
+// tokenBefore returns the text of the n-th token before offset.
+func tokenBefore(filter string, offset, n int) string {
+	toks, err := lexer.ConsumeAll(mustLex(filter[:min(offset, len(filter))]))
+	if err != nil || len(toks) < n+1 {
+		return ""
+	}
+	return toks[len(toks)-n-1].Value // the last token is EOF
+}
+
+var wordRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func isWord(s string) bool { return wordRegex.MatchString(s) }
+
+func isNumber(s string) bool { return s != "" && strings.Trim(s, "0123456789.") == "" }
+
+// isValue checks if s is a literal, a variable or a parameter.
+func isValue(s string) bool {
+	return isNumber(s) || (isWord(s) && !isKeyword(s)) || strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'") ||
+		strings.HasPrefix(s, ":") || s == "?"
+}
+
+func isKeyword(s string) bool {
+	for _, k := range keywords {
+		if strings.EqualFold(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLikeKeyword(s string) bool {
+	s = strings.ToUpper(s)
+	return s == "LIKE" || s == "ILIKE" || s == "RLIKE" || s == "GLOB" || s == "IGLOB"
+}
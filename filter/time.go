@@ -0,0 +1,167 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the formats that are accepted when text is compared to a time.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+	time.DateOnly,
+	"2006-01",
+	"2006",
+}
+
+const timeFormat = "2006-01-02 15:04:05.999999999"
+
+// ParseTime parses a date or time in one of the formats "YYYY-MM-DD HH:MM:SS",
+// "YYYY-MM-DD HH:MM", "YYYY-MM-DD", "YYYY-MM", "YYYY" or RFC 3339. Times without
+// a timezone are in local time.
+func ParseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time \"%s\"", s)
+}
+
+var intervalUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// intervals have a fixed length, so there are no month or year units like
+// in RelativeTime.
+var varyingUnits = map[string]string{
+	"month": "30 day",
+	"year":  "365 day",
+}
+
+type interval time.Duration
+
+// toDuration converts a number of nanoseconds to a duration, it fails if the
+// number is outside the range of time.Duration.
+func toDuration(f float64) (time.Duration, error) {
+	if math.IsNaN(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, ErrOverflow
+	}
+	return time.Duration(f), nil
+}
+
+func (d *interval) Capture(v []string) error {
+	if len(v) != 2 {
+		return errors.New("expected a number and a unit after INTERVAL")
+	}
+	n, err := strconv.ParseFloat(v[0], 64)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(strings.ToLower(v[1]), "s")
+	unit, ok := intervalUnits[name]
+	if !ok {
+		if alt, ok := varyingUnits[name]; ok {
+			return fmt.Errorf("invalid interval unit \"%s\" (use e.g. INTERVAL %s or \"1 %s ago\")", v[1], alt, name)
+		}
+		return fmt.Errorf("invalid interval unit \"%s\"", v[1])
+	}
+	r, err := toDuration(n * float64(unit))
+	if err != nil {
+		return fmt.Errorf("interval %s %s is out of range", v[0], v[1])
+	}
+	*d = interval(r)
+	return nil
+}
+
+func timeValue(t time.Time) *value { return &value{Time: &t} }
+
+func intervalValue(d time.Duration) *value { i := interval(d); return &value{Interval: &i} }
+
+// asTime returns the value as a time, text is parsed with ParseTime or
+// RelativeTime.
+func (v *value) asTime(now time.Time) (time.Time, error) {
+	switch {
+	case v.Time != nil:
+		return *v.Time, nil
+	case v.Text != nil:
+		if t, ok := RelativeTime(*v.Text, now); ok {
+			return t, nil
+		}
+		return ParseTime(*v.Text)
+	default:
+		return time.Time{}, ErrInvalidOperatorOrOperands
+	}
+}
+
+func arithTime(op string, v1, v2 *value) (*value, error) {
+	switch {
+	case v1.Time != nil && v2.Interval != nil && (op == "+" || op == "-"):
+		d := time.Duration(*v2.Interval)
+		if op == "-" {
+			d = -d
+		}
+		return timeValue(v1.Time.Add(d)), nil
+	case v1.Interval != nil && v2.Time != nil && op == "+":
+		return timeValue(v2.Time.Add(time.Duration(*v1.Interval))), nil
+	case v1.Time != nil && v2.Time != nil && op == "-":
+		return intervalValue(v1.Time.Sub(*v2.Time)), nil
+	case v1.Interval != nil && v2.Interval != nil && (op == "+" || op == "-"):
+		d1, d2 := *v1.Interval, *v2.Interval
+		if op == "-" {
+			d2 = -d2
+		}
+		return intervalValue(time.Duration(d1 + d2)), nil
+	case v1.Interval != nil && v2.isNum() && (op == "*" || op == "/"):
+		d, n := float64(*v1.Interval), v2.float()
+		if op == "*" {
+			d *= n
+		} else if n == 0 {
+			return nil, ErrDivisionByZero
+		} else {
+			d /= n
+		}
+		r, err := toDuration(d)
+		if err != nil {
+			return nil, err
+		}
+		return intervalValue(r), nil
+	case v1.isNum() && v2.Interval != nil && op == "*":
+		r, err := toDuration(v1.float() * float64(*v2.Interval))
+		if err != nil {
+			return nil, err
+		}
+		return intervalValue(r), nil
+	}
+	return nil, typeError(op, v1, v2)
+}
+
+func cmpResult(op string, c int) (*value, error) {
+	r := false
+	switch op {
+	case "!=", "<>":
+		r = c != 0
+	case "<=":
+		r = c <= 0
+	case ">=":
+		r = c >= 0
+	case "=":
+		r = c == 0
+	case "<":
+		r = c < 0
+	case ">":
+		r = c > 0
+	default:
+		return nil, ErrInvalidOperatorOrOperands
+	}
+	return boolValue(r), nil
+}
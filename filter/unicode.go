@@ -0,0 +1,67 @@
+package filter
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// WithNormalization converts text to a Unicode normalization form (e.g.
+// norm.NFC) before it is compared with =, !=, IN, LIKE, ILIKE, GLOB or IGLOB,
+// so that "café" matches no matter if "é" is stored as one or two code points.
+func WithNormalization(form norm.Form) Option {
+	return func(o *options) { o.norm = &form }
+}
+
+// WithCaseFolding makes =, !=, IN and LIKE ignore case. Like ILIKE it uses
+// full Unicode case folding, e.g. "Straße" equals "STRASSE".
+func WithCaseFolding() Option {
+	return func(o *options) { o.fold = true }
+}
+
+func (o options) compiler() *compiler {
+	return &compiler{schema: o.schema, text: o.textTransform(o.fold), norm: o.textTransform(false), fold: o.textTransform(true)}
+}
+
+// textTransform returns the function that prepares text for a comparison,
+// nil if the text is compared as is.
+func (o options) textTransform(fold bool) func(string) string {
+	switch {
+	case o.norm != nil && fold:
+		form := *o.norm
+		return func(s string) string { return foldCase(form.String(s)) }
+	case o.norm != nil:
+		return o.norm.String
+	case fold:
+		return foldCase
+	}
+	return nil
+}
+
+// foldCase applies full Unicode case folding to s.
+func foldCase(s string) string {
+	if isASCII(s) {
+		return strings.ToLower(s)
+	}
+	// a Caser must not be used concurrently
+	return cases.Fold().String(s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// transformText applies fn to a text value.
+func transformText(fn func(string) string, v *value) *value {
+	if fn == nil || v.Text == nil {
+		return v
+	}
+	return textValue(fn(*v.Text))
+}
@@ -3,13 +3,69 @@ package filter
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Value is a type that can represent a number, a string, or a boolean value.
+// Kind is the type of a Value.
+type Kind int
+
+const (
+	// KindAny is used when the kind is not known before evaluation.
+	KindAny Kind = iota
+	KindNumber
+	KindText
+	KindBool
+	KindTime
+	KindInterval
+	KindList
+	KindRows
+	KindNull
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNumber:
+		return "number"
+	case KindText:
+		return "text"
+	case KindBool:
+		return "boolean"
+	case KindTime:
+		return "time"
+	case KindInterval:
+		return "interval"
+	case KindList:
+		return "list"
+	case KindRows:
+		return "rows"
+	case KindNull:
+		return "null"
+	default:
+		return "any"
+	}
+}
+
+func (k Kind) accepts(k2 Kind) bool {
+	return k == KindAny || k2 == KindAny || k2 == KindNull || k == k2
+}
+
+// Value is a type that can represent a number, a string, a boolean value, a
+// point in time, a time interval or a list of values. A Value without any
+// field set is NULL. Numbers are either integers (Number) or floating-point
+// numbers (Float), both are of KindNumber. A list is empty if List is not nil
+// but has no elements. Rows are records (like the entries of an archive) that
+// can only be queried with EXISTS and COUNT.
 type Value struct {
-	Number  *int64
-	Text    *string
-	Boolean *bool
+	Number   *int64
+	Float    *float64
+	Text     *string
+	Boolean  *bool
+	Time     *time.Time
+	Interval *time.Duration
+	List     []*Value
+	Rows     func() ([]VariableGetter, error)
 }
 
 // String returns a string representation of the value. If the value is nil, an empty
@@ -21,27 +77,88 @@ func (v *Value) String() string {
 	switch {
 	case v.Number != nil:
 		return fmt.Sprintf("%d", *v.Number)
+	case v.Float != nil:
+		return strconv.FormatFloat(*v.Float, 'g', -1, 64)
 	case v.Text != nil:
 		return *v.Text
 	case v.Boolean != nil:
 		return fmt.Sprintf("%t", *v.Boolean)
+	case v.Time != nil:
+		return v.Time.Format(timeFormat)
+	case v.Interval != nil:
+		return v.Interval.String()
+	case v.List != nil:
+		items := make([]string, len(v.List))
+		for i, item := range v.List {
+			items[i] = item.String()
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case v.Rows != nil:
+		return "(rows)"
 	default:
 		return ""
 	}
 }
 
+// Kind returns the kind of the value.
+func (v *Value) Kind() Kind {
+	switch {
+	case v == nil:
+		return KindNull
+	case v.Number != nil, v.Float != nil:
+		return KindNumber
+	case v.Text != nil:
+		return KindText
+	case v.Boolean != nil:
+		return KindBool
+	case v.Time != nil:
+		return KindTime
+	case v.Interval != nil:
+		return KindInterval
+	case v.List != nil:
+		return KindList
+	case v.Rows != nil:
+		return KindRows
+	default:
+		return KindNull
+	}
+}
+
+// IsNull returns true if the value is NULL, i.e. the property does not apply.
+func (v *Value) IsNull() bool { return v.Kind() == KindNull }
+
 func (v *Value) tovalue(name string) (*value, error) {
 	if v != nil {
-		return &value{
-			Number:  v.Number,
-			Text:    v.Text,
-			Boolean: (*boolean)(v.Boolean),
-		}, nil
+		r := &value{
+			Number:   v.Number,
+			Float:    v.Float,
+			Text:     v.Text,
+			Boolean:  (*boolean)(v.Boolean),
+			Time:     v.Time,
+			Interval: (*interval)(v.Interval),
+			Rows:     v.Rows,
+		}
+		if v.List != nil {
+			r.List = make([]*value, len(v.List))
+			for i, item := range v.List {
+				if r.List[i], _ = item.tovalue(name); item == nil {
+					r.List[i] = nullValue()
+				}
+			}
+		}
+		return r, nil
 	} else {
 		return &value{}, errors.New(fmt.Sprintf("\"%s\" is unknown", name))
 	}
 }
 
+// NullValue creates a new Value instance that represents NULL. Use it for
+// properties that do not apply, e.g. the container of a file that is not in an
+// archive.
+func NullValue() *Value {
+	return &Value{}
+}
+
 // NumberValue creates a new Value instance that represents the given number.
 func NumberValue(f int64) *Value {
 	return &Value{
@@ -49,6 +166,13 @@ func NumberValue(f int64) *Value {
 	}
 }
 
+// FloatValue creates a new Value instance that represents the given floating-point number.
+func FloatValue(f float64) *Value {
+	return &Value{
+		Float: &f,
+	}
+}
+
 // TextValue creates a new Value instance that represents the given string.
 func TextValue(s string) *Value {
 	return &Value{
@@ -63,3 +187,45 @@ func BoolValue(v bool) *Value {
 		Boolean: &b,
 	}
 }
+
+// TimeValue creates a new Value instance that represents the given point in time.
+func TimeValue(t time.Time) *Value {
+	return &Value{
+		Time: &t,
+	}
+}
+
+// IntervalValue creates a new Value instance that represents the given time interval.
+func IntervalValue(d time.Duration) *Value {
+	return &Value{
+		Interval: &d,
+	}
+}
+
+// ListValue creates a new Value instance that represents a list of the given
+// values, e.g. the segments of a path.
+func ListValue(items ...*Value) *Value {
+	return &Value{
+		List: append([]*Value{}, items...),
+	}
+}
+
+// TextListValue creates a new Value instance that represents a list of texts.
+func TextListValue(items []string) *Value {
+	list := make([]*Value, len(items))
+	for i, s := range items {
+		list[i] = TextValue(s)
+	}
+	return &Value{
+		List: list,
+	}
+}
+
+// RowsValue creates a new Value instance that represents records like the
+// entries of an archive. rows is only called when a filter queries them with
+// EXISTS or COUNT.
+func RowsValue(rows func() ([]VariableGetter, error)) *Value {
+	return &Value{
+		Rows: rows,
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"archive/zip"
 	"compress/bzip2"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bodgit/sevenzip"
@@ -28,6 +31,20 @@ type FileInfo struct {
 	Type      string
 	Container string
 	Archive   string
+	// Mode holds the type and permission bits, it is only valid if ModeKnown
+	// is set (archives do not always store Unix permissions).
+	Mode      os.FileMode
+	ModeKnown bool
+	// ContainerInfo describes the archive that contains the file, nil if the
+	// file is not inside an archive.
+	ContainerInfo *FileInfo
+	// Target describes the file that a link points to, nil for other files
+	// or if the target does not exist.
+	Target *FileInfo
+	// entries lists the entries of an archive, nil for other files.
+	entries func() ([]FileInfo, error)
+	// parentInfo returns the parent directory on disk, it is read once.
+	parentInfo func() *FileInfo
 }
 
 // IsDir returns a boolean value indicating if the FileInfo instance is a
@@ -36,14 +53,120 @@ func (fi FileInfo) IsDir() bool { return fi.Type == "dir" }
 
 func (fi FileInfo) fromSymlink(fi2 FileInfo) FileInfo {
 	return FileInfo{
-		Name:    fi.Name,
-		Path:    fi.Path,
-		ModTime: fi2.ModTime,
-		Size:    fi2.Size,
-		Type:    fi2.Type,
+		Name:       fi.Name,
+		Path:       fi.Path,
+		ModTime:    fi2.ModTime,
+		Size:       fi2.Size,
+		Type:       fi2.Type,
+		Mode:       fi2.Mode,
+		ModeKnown:  fi2.ModeKnown,
+		Target:     &fi2,
+		parentInfo: fi.parentInfo,
 	}
 }
 
+// ModeString returns the type and permissions like ls -l, e.g. "-rwxr-xr-x".
+// Unknown permissions are shown as "?".
+func (fi FileInfo) ModeString() string {
+	b := []byte("-?????????")
+	switch {
+	case fi.Mode&os.ModeDir != 0 || fi.IsDir():
+		b[0] = 'd'
+	case fi.Mode&os.ModeSymlink != 0 || fi.Type == "link":
+		b[0] = 'l'
+	case fi.Mode&os.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case fi.Mode&os.ModeSocket != 0:
+		b[0] = 's'
+	case fi.Mode&os.ModeCharDevice != 0:
+		b[0] = 'c'
+	case fi.Mode&os.ModeDevice != 0:
+		b[0] = 'b'
+	}
+	if !fi.ModeKnown {
+		return string(b)
+	}
+	const rwx = "rwxrwxrwx"
+	for i := range 9 {
+		b[i+1] = '-'
+		if fi.Mode&(1<<(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+	// the special bits replace the execute permission, in upper case if it
+	// is not set
+	special := func(i int, set bool, c byte) {
+		if set && b[i] == 'x' {
+			b[i] = c
+		} else if set {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	special(3, fi.Mode&os.ModeSetuid != 0, 's')
+	special(6, fi.Mode&os.ModeSetgid != 0, 's')
+	special(9, fi.Mode&os.ModeSticky != 0, 't')
+	return string(b)
+}
+
+// unixPerm returns the permission bits including setuid, setgid and sticky
+// as they are used by chmod, e.g. 04755.
+func unixPerm(mode os.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 0o1000
+	}
+	return perm
+}
+
+// parent returns the directory that contains the file. Inside an archive
+// only its path is known, at the root of an archive the parent is the archive
+// itself. On disk the path of the parent is absolute, so that the parent of
+// a file in the current directory has a name.
+func (fi FileInfo) parent() *FileInfo {
+	if fi.ContainerInfo != nil {
+		dir := path.Dir(strings.TrimSuffix(fi.Path, "/"))
+		if dir == "." || dir == "/" {
+			return fi.ContainerInfo
+		}
+		return &FileInfo{
+			Name:          path.Base(dir),
+			Path:          dir,
+			Type:          "dir",
+			Container:     fi.Container,
+			Archive:       fi.Archive,
+			ContainerInfo: fi.ContainerInfo,
+		}
+	}
+	if fi.parentInfo != nil {
+		return fi.parentInfo()
+	}
+	return diskParent(fi.Path)
+}
+
+// diskParent returns the directory that contains path, nil for the root.
+func diskParent(path string) *FileInfo {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	if dir == path {
+		return nil
+	}
+	osFileInfo, err := os.Lstat(dir)
+	if err != nil {
+		return nil
+	}
+	parent := makeFileInfo(dir, osFileInfo)
+	return &parent
+}
+
 // FindError is a type that represents an error that occurred during a file search.
 type FindError struct {
 	Path string
@@ -63,8 +186,23 @@ const (
 	fieldExt2      = "ext2"
 	fieldType      = "type"
 	fieldArchive   = "archive"
+	fieldMtime     = "mtime"
+	fieldSegments  = "segments"
+	fieldExts      = "exts"
+	fieldEntries   = "entries"
+	fieldMode      = "mode"
+	fieldPerm      = "perm"
+	fieldExec      = "is_executable"
+	fieldSetuid    = "setuid"
+	fieldSetgid    = "setgid"
+	fieldSticky    = "sticky"
+	fieldWorldW    = "world_writable"
 )
 
+// relations are the prefixes of dotted names that address the fields of a
+// related file, e.g. "container.date".
+var relations = [...]string{"container", "target", "parent"}
+
 // Fields is a slice of the constants that address fields in the FileInfo type.
 var Fields = [...]string{
 	fieldName,
@@ -79,58 +217,238 @@ var Fields = [...]string{
 	fieldArchive,
 }
 
+// Schema describes the fields and helper properties that are provided by
+// Context, it can be passed to filter.WithSchema.
+var Schema = filter.Schema{
+	fieldName:      filter.KindText,
+	fieldPath:      filter.KindText,
+	fieldContainer: filter.KindText,
+	fieldSize:      filter.KindNumber,
+	fieldDate:      filter.KindText,
+	fieldTime:      filter.KindText,
+	fieldExt:       filter.KindText,
+	fieldExt2:      filter.KindText,
+	fieldType:      filter.KindText,
+	fieldArchive:   filter.KindText,
+	fieldMtime:     filter.KindTime,
+	fieldSegments:  filter.KindList,
+	fieldExts:      filter.KindList,
+	fieldEntries:   filter.KindRows,
+	fieldMode:      filter.KindText,
+	fieldPerm:      filter.KindText,
+	fieldExec:      filter.KindBool,
+	fieldSetuid:    filter.KindBool,
+	fieldSetgid:    filter.KindBool,
+	fieldSticky:    filter.KindBool,
+	fieldWorldW:    filter.KindBool,
+	"today":        filter.KindText,
+	"yesterday":    filter.KindText,
+	"this_week":    filter.KindText,
+	"last_week":    filter.KindText,
+	"this_month":   filter.KindText,
+	"last_month":   filter.KindText,
+	"this_year":    filter.KindText,
+	"last_year":    filter.KindText,
+	"mo":           filter.KindText,
+	"tu":           filter.KindText,
+	"we":           filter.KindText,
+	"th":           filter.KindText,
+	"fr":           filter.KindText,
+	"sa":           filter.KindText,
+	"su":           filter.KindText,
+}
+
+// relatedFields are the fields that can be addressed with a relation prefix.
+var relatedFields = map[string]bool{}
+
+func init() {
+	for _, f := range Fields {
+		relatedFields[f] = true
+	}
+	for _, f := range []string{fieldMtime, fieldSegments, fieldExts, fieldMode, fieldPerm,
+		fieldExec, fieldSetuid, fieldSetgid, fieldSticky, fieldWorldW} {
+		relatedFields[f] = true
+	}
+	for _, rel := range relations {
+		for f := range relatedFields {
+			Schema[rel+"."+f] = Schema[f]
+		}
+	}
+}
+
 // Context is a method of the FileInfo type that returns a VariableGetter function
 // that can be used to retrieve the values of the fields of the file or directory
 // represented by the FileInfo instance.
 //
-// It also generates helper properties like "today".
+// It also generates helper properties like "today" and provides the fields of
+// related files with dotted names like "container.date", "target.path" or
+// "parent.name". They are NULL if the file has no such relation. The entries
+// of an archive can be queried with exists(entries WHERE ...).
+//
+// The helper properties are based on the current time, use ContextAt to
+// match the time of a filter (see filter.WithNow).
 func (file FileInfo) Context() filter.VariableGetter {
+	return file.ContextAt(time.Now())
+}
+
+// ContextAt is like Context but the helper properties like "today" are
+// relative to now.
+func (file FileInfo) ContextAt(now time.Time) filter.VariableGetter {
 	return func(name string) *filter.Value {
+		if v := file.field(name, now); v != nil {
+			return v
+		}
 		switch strings.ToLower(name) {
-		case fieldName:
-			return filter.TextValue(file.Name)
-		case fieldPath:
-			return filter.TextValue(file.Path)
-		case fieldDate:
-			return filter.TextValue(file.ModTime.Format(time.DateOnly))
-		case fieldTime:
-			return filter.TextValue(file.ModTime.Format(time.TimeOnly))
-		case fieldSize:
-			return filter.NumberValue(file.Size)
-		case fieldExt:
-			return filter.TextValue(strings.TrimPrefix(filepath.Ext(file.Name), "."))
-		case fieldExt2:
-			return filter.TextValue(strings.TrimPrefix(ext2(file.Name), "."))
-		case fieldType:
-			return filter.TextValue(file.Type)
-		case fieldContainer:
-			return filter.TextValue(file.Container)
-		case fieldArchive:
-			return filter.TextValue(file.Archive)
-		case "today":
-			return filter.TextValue(time.Now().Format(time.DateOnly))
+		case "today", "yesterday", "this_week", "last_week", "this_month", "last_month", "this_year", "last_year":
+			t, _ := filter.RelativeTime(name, now)
+			return filter.TextValue(t.Format(time.DateOnly))
 		case "mo":
-			return getLastWeekday(time.Monday)
+			return getLastWeekday(time.Monday, now)
 		case "tu":
-			return getLastWeekday(time.Tuesday)
+			return getLastWeekday(time.Tuesday, now)
 		case "we":
-			return getLastWeekday(time.Wednesday)
+			return getLastWeekday(time.Wednesday, now)
 		case "th":
-			return getLastWeekday(time.Thursday)
+			return getLastWeekday(time.Thursday, now)
 		case "fr":
-			return getLastWeekday(time.Friday)
+			return getLastWeekday(time.Friday, now)
 		case "sa":
-			return getLastWeekday(time.Saturday)
+			return getLastWeekday(time.Saturday, now)
 		case "su":
-			return getLastWeekday(time.Sunday)
+			return getLastWeekday(time.Sunday, now)
+		default:
+			return nil
+		}
+	}
+}
+
+// field returns the value of a field of the file or of a related file, nil
+// if the name is unknown. The entries of an archive use now for their
+// helper properties.
+func (file FileInfo) field(name string, now time.Time) *filter.Value {
+	if rel, rest, ok := strings.Cut(name, "."); ok {
+		if !relatedFields[strings.ToLower(rest)] {
+			return nil
+		}
+		var related *FileInfo
+		switch strings.ToLower(rel) {
+		case "container":
+			related = file.ContainerInfo
+		case "target":
+			related = file.Target
+		case "parent":
+			related = file.parent()
 		default:
 			return nil
 		}
+		if related == nil {
+			return filter.NullValue()
+		}
+		return related.field(rest, now)
+	}
+	switch strings.ToLower(name) {
+	case fieldName:
+		return filter.TextValue(file.Name)
+	case fieldPath:
+		return filter.TextValue(file.Path)
+	case fieldDate:
+		return timeOrNull(file.ModTime, time.DateOnly)
+	case fieldTime:
+		return timeOrNull(file.ModTime, time.TimeOnly)
+	case fieldMtime:
+		if file.ModTime.IsZero() {
+			return filter.NullValue()
+		}
+		return filter.TimeValue(file.ModTime)
+	case fieldSize:
+		return filter.NumberValue(file.Size)
+	case fieldExt:
+		return filter.TextValue(strings.TrimPrefix(filepath.Ext(file.Name), "."))
+	case fieldExt2:
+		return filter.TextValue(strings.TrimPrefix(ext2(file.Name), "."))
+	case fieldExts:
+		return filter.TextListValue(exts(file.Name))
+	case fieldSegments:
+		return filter.TextListValue(segments(file.Path))
+	case fieldMode, fieldPerm, fieldExec, fieldSetuid, fieldSetgid, fieldSticky, fieldWorldW:
+		return file.modeField(strings.ToLower(name))
+	case fieldEntries:
+		if file.entries == nil {
+			return filter.NullValue()
+		}
+		return filter.RowsValue(func() ([]filter.VariableGetter, error) {
+			files, err := file.entries()
+			var ferr *FindError
+			if errors.As(err, &ferr) {
+				return nil, ferr.Err // the error is reported for the archive
+			} else if err != nil {
+				return nil, err
+			}
+			rows := make([]filter.VariableGetter, len(files))
+			for i, f := range files {
+				rows[i] = f.ContextAt(now)
+			}
+			return rows, nil
+		})
+	case fieldType:
+		return filter.TextValue(file.Type)
+	case fieldContainer:
+		return textOrNull(file.Container)
+	case fieldArchive:
+		return textOrNull(file.Archive)
+	default:
+		return nil
 	}
 }
 
-func getLastWeekday(weekday time.Weekday) *filter.Value {
-	now := time.Now()
+// textOrNull returns NULL for properties that do not apply to the file.
+func textOrNull(s string) *filter.Value {
+	if s == "" {
+		return filter.NullValue()
+	}
+	return filter.TextValue(s)
+}
+
+// modeField returns the value of a field that is derived from the mode, NULL
+// if the mode is not known. The permissions of a link are not used to access
+// its target, so only the mode is shown for links and the other fields are
+// NULL (see target.perm).
+func (file FileInfo) modeField(name string) *filter.Value {
+	mode := file.Mode
+	if !file.ModeKnown {
+		return filter.NullValue()
+	}
+	if name == fieldMode {
+		return filter.TextValue(file.ModeString())
+	} else if file.Type == "link" {
+		return filter.NullValue()
+	}
+	switch name {
+	case fieldPerm:
+		return filter.TextValue(fmt.Sprintf("%04o", unixPerm(mode)))
+	case fieldExec:
+		return filter.BoolValue(!mode.IsDir() && mode&0o111 != 0)
+	case fieldSetuid:
+		return filter.BoolValue(mode&os.ModeSetuid != 0)
+	case fieldSetgid:
+		return filter.BoolValue(mode&os.ModeSetgid != 0)
+	case fieldSticky:
+		return filter.BoolValue(mode&os.ModeSticky != 0)
+	default:
+		return filter.BoolValue(mode&0o002 != 0)
+	}
+}
+
+// timeOrNull returns NULL if the time is not known, e.g. for a directory
+// inside an archive that has no entry of its own.
+func timeOrNull(t time.Time, layout string) *filter.Value {
+	if t.IsZero() {
+		return filter.NullValue()
+	}
+	return filter.TextValue(t.Format(layout))
+}
+
+func getLastWeekday(weekday time.Weekday, now time.Time) *filter.Value {
 	offs := int(weekday - now.Weekday())
 	if offs >= 0 {
 		offs -= 7
@@ -163,6 +481,7 @@ func listFilesInTar(fullpath string) ([]FileInfo, error) {
 	r := tar.NewReader(fr)
 
 	var files []FileInfo
+	links := map[int]string{}
 	for {
 		h, err := r.Next()
 		if err == io.EOF {
@@ -178,6 +497,9 @@ func listFilesInTar(fullpath string) ([]FileInfo, error) {
 				t = "dir"
 			} else if h.Typeflag == tar.TypeSymlink {
 				t = "link"
+				if !path.IsAbs(h.Linkname) {
+					links[len(files)] = path.Join(path.Dir(h.Name), h.Linkname)
+				}
 			}
 
 			files = append(files, FileInfo{
@@ -187,7 +509,20 @@ func listFilesInTar(fullpath string) ([]FileInfo, error) {
 				Size:      h.Size,
 				Type:      t,
 				Container: fullpath,
-				Archive:   "tar"})
+				Archive:   "tar",
+				Mode:      h.FileInfo().Mode(),
+				ModeKnown: true})
+		}
+	}
+
+	for i, target := range links {
+		for j := range files {
+			if strings.TrimSuffix(files[j].Path, "/") == target {
+				// copy, the files are sorted later
+				target := files[j]
+				files[i].Target = &target
+				break
+			}
 		}
 	}
 
@@ -202,6 +537,10 @@ func getZipNameAndType(path string) (string, string) {
 	}
 }
 
+// zipCreatorUnix is the "version made by" of zip files that store Unix
+// permissions.
+const zipCreatorUnix = 3
+
 func listFilesInZip(fullpath string) ([]FileInfo, error) {
 	f, err := os.Open(fullpath)
 	if err != nil {
@@ -226,6 +565,11 @@ func listFilesInZip(fullpath string) ([]FileInfo, error) {
 		}
 		defer rc.Close()
 		name, t := getZipNameAndType(zf.Name)
+		var mode os.FileMode
+		known := zf.CreatorVersion>>8 == zipCreatorUnix
+		if known {
+			mode = zf.Mode()
+		}
 		files = append(files, FileInfo{
 			Name:      filepath.Base(name),
 			Path:      name,
@@ -233,11 +577,17 @@ func listFilesInZip(fullpath string) ([]FileInfo, error) {
 			Size:      int64(zf.UncompressedSize),
 			Type:      t,
 			Container: fullpath,
-			Archive:   "zip"})
+			Archive:   "zip",
+			Mode:      mode,
+			ModeKnown: known})
 	}
 	return files, nil
 }
 
+// sevenZipUnixExtension is set in the attributes of 7z entries whose upper
+// 16 bits hold Unix permissions.
+const sevenZipUnixExtension = 0x8000
+
 func listFilesIn7Zip(fullpath string) ([]FileInfo, error) {
 
 	r, err := sevenzip.OpenReader(fullpath)
@@ -250,6 +600,11 @@ func listFilesIn7Zip(fullpath string) ([]FileInfo, error) {
 	for _, h := range r.File {
 
 		name, t := getZipNameAndType(h.Name)
+		var mode os.FileMode
+		known := h.Attributes&sevenZipUnixExtension != 0
+		if known {
+			mode = h.Mode()
+		}
 		files = append(files, FileInfo{
 			Name:      filepath.Base(name),
 			Path:      name,
@@ -257,7 +612,9 @@ func listFilesIn7Zip(fullpath string) ([]FileInfo, error) {
 			Size:      h.FileInfo().Size(),
 			Type:      t,
 			Container: fullpath,
-			Archive:   "7z"})
+			Archive:   "7z",
+			Mode:      mode,
+			ModeKnown: known})
 	}
 
 	return files, nil
@@ -282,6 +639,11 @@ func listFilesInRar(fullpath string) ([]FileInfo, error) {
 		if h.IsDir {
 			t = "dir"
 		}
+		var mode os.FileMode
+		known := h.HostOS == rardecode.HostOSUnix
+		if known {
+			mode = h.Mode()
+		}
 
 		files = append(files, FileInfo{
 			Name:      filepath.Base(h.Name),
@@ -290,52 +652,88 @@ func listFilesInRar(fullpath string) ([]FileInfo, error) {
 			Size:      h.UnPackedSize,
 			Type:      t,
 			Container: fullpath,
-			Archive:   "rar"})
+			Archive:   "rar",
+			Mode:      mode,
+			ModeKnown: known})
 	}
 
 	return files, nil
 }
 
+// archiveLister returns the function that lists the files in an archive, nil
+// if fullpath is not a supported archive.
+func archiveLister(fullpath string) func(string) ([]FileInfo, error) {
+	switch {
+	case strings.HasSuffix(fullpath, ".tar") ||
+		strings.HasSuffix(fullpath, ".tar.gz") || strings.HasSuffix(fullpath, ".tgz") ||
+		strings.HasSuffix(fullpath, ".tar.bz2") || strings.HasSuffix(fullpath, ".tbz2") ||
+		strings.HasSuffix(fullpath, ".tar.xz") || strings.HasSuffix(fullpath, ".txz"):
+		return listFilesInTar
+	case strings.HasSuffix(fullpath, ".zip"):
+		return listFilesInZip
+	case strings.HasSuffix(fullpath, ".7z"):
+		return listFilesIn7Zip
+	case strings.HasSuffix(fullpath, ".rar"):
+		return listFilesInRar
+	}
+	return nil
+}
+
+// withEntries returns a copy of the archive fi that lists its entries with
+// list. They are read once, either when the filter queries them or when they
+// are searched.
+func withEntries(fi FileInfo, list func(string) ([]FileInfo, error)) FileInfo {
+	container := &fi
+	var once sync.Once
+	var files []FileInfo
+	var err error
+	container.entries = func() ([]FileInfo, error) {
+		once.Do(func() {
+			if files, err = list(container.Path); err != nil {
+				return
+			}
+			sort.Slice(files, func(i, j int) bool {
+				return files[i].Path < files[j].Path
+			})
+			for i := range files {
+				files[i].ContainerInfo = container
+				if files[i].Target != nil {
+					// links in an archive point to entries of the same archive
+					files[i].Target.ContainerInfo = container
+				}
+			}
+		})
+		return files, err
+	}
+	return *container
+}
+
 func findIn(param WalkParams, fi FileInfo) {
 
 	fullpath := fi.Path
 
-	if ok, err := param.Filter.Test(fi.Context()); err != nil {
+	if !fi.IsDir() && !param.NoArchive {
+		if list := archiveLister(fullpath); list != nil {
+			fi = withEntries(fi, list)
+		}
+	}
+
+	if ok, err := param.Filter.Test(fi.ContextAt(param.Filter.Now())); err != nil {
 		param.sendErr(&FindError{Path: fullpath, Err: err})
 		return
 	} else if ok {
 		param.Chan <- fi
 	}
 
-	var files []FileInfo
-	var err error = nil
-
-	if fi.IsDir() || param.NoArchive {
+	if fi.entries == nil {
 		return
 	}
 
-	if strings.HasSuffix(fullpath, ".tar") ||
-		strings.HasSuffix(fullpath, ".tar.gz") || strings.HasSuffix(fullpath, ".tgz") ||
-		strings.HasSuffix(fullpath, ".tar.bz2") || strings.HasSuffix(fullpath, ".tbz2") ||
-		strings.HasSuffix(fullpath, ".tar.xz") || strings.HasSuffix(fullpath, ".txz") {
-		files, err = listFilesInTar(fullpath)
-	} else if strings.HasSuffix(fullpath, ".zip") {
-		files, err = listFilesInZip(fullpath)
-	} else if strings.HasSuffix(fullpath, ".7z") {
-		files, err = listFilesIn7Zip(fullpath)
-	} else if strings.HasSuffix(fullpath, ".rar") {
-		files, err = listFilesInRar(fullpath)
-	}
-
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Path < files[j].Path
-	})
-
-	if err != nil {
+	if files, err := fi.entries(); err != nil {
 		param.sendErr(err)
 	} else {
 		for _, fi2 := range files {
-			if ok, err := param.Filter.Test(fi2.Context()); err != nil {
+			if ok, err := param.Filter.Test(fi2.ContextAt(param.Filter.Now())); err != nil {
 				param.sendErr(&FindError{Path: fullpath, Err: err})
 				return
 			} else if ok {
@@ -0,0 +1,187 @@
+package find
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModeString(t *testing.T) {
+	for _, ex := range []struct {
+		fi     FileInfo
+		expect string
+	}{
+		{FileInfo{Type: "file", Mode: 0o644, ModeKnown: true}, "-rw-r--r--"},
+		{FileInfo{Type: "file", Mode: 0, ModeKnown: true}, "----------"},
+		{FileInfo{Type: "file", Mode: 0o755 | os.ModeSetuid, ModeKnown: true}, "-rwsr-xr-x"},
+		{FileInfo{Type: "file", Mode: 0o644 | os.ModeSetuid, ModeKnown: true}, "-rwSr--r--"},
+		{FileInfo{Type: "file", Mode: 0o750 | os.ModeSetgid, ModeKnown: true}, "-rwxr-s---"},
+		{FileInfo{Type: "dir", Mode: 0o777 | os.ModeDir | os.ModeSticky, ModeKnown: true}, "drwxrwxrwt"},
+		{FileInfo{Type: "dir", Mode: 0o770 | os.ModeDir | os.ModeSticky, ModeKnown: true}, "drwxrwx--T"},
+		{FileInfo{Type: "link", Mode: 0o777 | os.ModeSymlink, ModeKnown: true}, "lrwxrwxrwx"},
+		{FileInfo{Type: "file", Mode: 0o600 | os.ModeNamedPipe, ModeKnown: true}, "prw-------"},
+		{FileInfo{Type: "file"}, "-?????????"},
+		{FileInfo{Type: "dir"}, "d?????????"},
+	} {
+		if s := ex.fi.ModeString(); s != ex.expect {
+			t.Errorf("%v: got %s, expected %s", ex.fi.Mode, s, ex.expect)
+		}
+	}
+}
+
+func TestUnixPerm(t *testing.T) {
+	for _, ex := range []struct {
+		mode   os.FileMode
+		expect uint32
+	}{
+		{0, 0},
+		{0o644, 0o644},
+		{0o755 | os.ModeDir, 0o755},
+		{0o755 | os.ModeSetuid, 0o4755},
+		{0o755 | os.ModeSetgid, 0o2755},
+		{0o777 | os.ModeDir | os.ModeSticky, 0o1777},
+		{0o777 | os.ModeSetuid | os.ModeSetgid | os.ModeSticky, 0o7777},
+	} {
+		if p := unixPerm(ex.mode); p != ex.expect {
+			t.Errorf("%v: got %04o, expected %04o", ex.mode, p, ex.expect)
+		}
+	}
+}
+
+func TestModeFields(t *testing.T) {
+	for _, ex := range []struct {
+		fi                      FileInfo
+		mode, perm, exec, world string
+	}{
+		{FileInfo{Type: "file", Mode: 0o755, ModeKnown: true}, "-rwxr-xr-x", "0755", "true", "false"},
+		{FileInfo{Type: "file", Mode: 0, ModeKnown: true}, "----------", "0000", "false", "false"},
+		{FileInfo{Type: "dir", Mode: 0o777 | os.ModeDir, ModeKnown: true}, "drwxrwxrwx", "0777", "false", "true"},
+		{FileInfo{Type: "link", Mode: 0o777 | os.ModeSymlink, ModeKnown: true}, "lrwxrwxrwx", "", "", ""},
+		{FileInfo{Type: "file"}, "", "", "", ""},
+	} {
+		get := ex.fi.Context()
+		for name, expect := range map[string]string{fieldMode: ex.mode, fieldPerm: ex.perm, fieldExec: ex.exec, fieldWorldW: ex.world} {
+			v := get(name)
+			if s := v.String(); s != expect || (expect == "") != v.IsNull() {
+				t.Errorf("%s of %v: got %q, expected %q", name, ex.fi.Mode, s, expect)
+			}
+		}
+	}
+}
+
+func TestArchiveModes(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tarPath := filepath.Join(dir, "a.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for _, h := range []*tar.Header{
+		{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: modTime},
+		{Name: "bin/run", Typeflag: tar.TypeReg, Mode: 0o4755, ModTime: modTime},
+		{Name: "bin/none", Typeflag: tar.TypeReg, Mode: 0, ModTime: modTime},
+		{Name: "bin/link", Typeflag: tar.TypeSymlink, Linkname: "run", Mode: 0o777, ModTime: modTime},
+		{Name: "tmp/", Typeflag: tar.TypeDir, Mode: 0o1777, ModTime: modTime},
+	} {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	zipPath := filepath.Join(dir, "a.zip")
+	f, err = os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	unix := &zip.FileHeader{Name: "unix.sh", Modified: modTime}
+	unix.SetMode(0o750 | os.ModeSetgid)
+	for _, h := range []*zip.FileHeader{
+		unix,
+		{Name: "dos.txt", Modified: modTime}, // no Unix permissions
+	} {
+		if _, err := zw.CreateHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tarFiles, err := listFilesInTar(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipFiles, err := listFilesInZip(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"bin/":     "drwxr-xr-x",
+		"bin/run":  "-rwsr-xr-x",
+		"bin/none": "----------",
+		"bin/link": "lrwxrwxrwx",
+		"tmp/":     "drwxrwxrwt",
+		"unix.sh":  "-rwxr-s---",
+		"dos.txt":  "-?????????",
+	}
+	for _, fi := range append(tarFiles, zipFiles...) {
+		if s := fi.ModeString(); s != expect[fi.Path] {
+			t.Errorf("%s: got %s, expected %s", fi.Path, s, expect[fi.Path])
+		}
+		delete(expect, fi.Path)
+	}
+	if len(expect) > 0 {
+		t.Errorf("missing entries: %v", expect)
+	}
+}
+
+func TestParent(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	osFileInfo, err := os.Lstat("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi := makeFileInfo("a", osFileInfo)
+	if p := fi.parent(); p == nil || p.Name != filepath.Base(dir) || !filepath.IsAbs(p.Path) {
+		t.Errorf("parent of a: %+v", p)
+	}
+	if p1, p2 := fi.parent(), fi.parent(); p1 != p2 {
+		t.Errorf("parent is read again")
+	}
+
+	container := &FileInfo{Name: "x.zip", Path: "x.zip", Type: "file"}
+	for _, ex := range []struct{ path, expect string }{
+		{"a/b/c.txt", "a/b"},
+		{"a/", "x.zip"},
+		{"c.txt", "x.zip"},
+	} {
+		entry := FileInfo{Path: ex.path, ContainerInfo: container}
+		if p := entry.parent(); p == nil || p.Path != ex.expect {
+			t.Errorf("parent of %s: %+v", ex.path, p)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package find
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
+	"sync"
 )
 
 type WalkFunc func(file *FileInfo, err error)
@@ -29,14 +32,31 @@ func makeFileInfo(fullpath string, file os.FileInfo) FileInfo {
 	}
 
 	return FileInfo{
-		Name:    file.Name(),
-		Path:    fullpath,
-		ModTime: file.ModTime(),
-		Size:    file.Size(),
-		Type:    t,
+		Name:       file.Name(),
+		Path:       fullpath,
+		ModTime:    file.ModTime(),
+		Size:       file.Size(),
+		Type:       t,
+		Mode:       file.Mode(),
+		ModeKnown:  true,
+		parentInfo: sync.OnceValue(func() *FileInfo { return diskParent(fullpath) }),
 	}
 }
 
+// linkTarget returns the file that a link points to, nil if it does not exist.
+func linkTarget(path string) *FileInfo {
+	rpath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil
+	}
+	osFileInfo, err := os.Lstat(rpath)
+	if err != nil {
+		return nil
+	}
+	target := makeFileInfo(rpath, osFileInfo)
+	return &target
+}
+
 func readDirNames(dirname string) ([]string, error) {
 	f, err := os.Open(dirname)
 	if err != nil {
@@ -69,7 +89,7 @@ func walk(path string, virtPath string, followSymlinks bool, report WalkFunc) {
 				report(nil, &WalkError{Path: path, Err: err})
 				return
 			}
-			fi2 := makeFileInfo(virtPath, osFileInfo)
+			fi2 := makeFileInfo(rpath, osFileInfo)
 			fi = fi.fromSymlink(fi2)
 			path = rpath
 			report(&fi, nil)
@@ -78,6 +98,9 @@ func walk(path string, virtPath string, followSymlinks bool, report WalkFunc) {
 			}
 		} else {
 			// file
+			if fi.Type == "link" {
+				fi.Target = linkTarget(path)
+			}
 			report(&fi, nil)
 			return
 		}
@@ -107,3 +130,16 @@ func ext2(path string) string {
 	}
 	return ""
 }
+
+// exts returns all extensions of a file name, e.g. "tar" and "gz".
+func exts(name string) []string {
+	parts := strings.Split(name, ".")[1:]
+	return slices.DeleteFunc(parts, func(s string) bool { return s == "" })
+}
+
+// segments returns the names of the directories in path, followed by the
+// name of the file.
+func segments(path string) []string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	return slices.DeleteFunc(parts, func(s string) bool { return s == "" || s == "." })
+}
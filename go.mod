@@ -9,15 +9,16 @@ require (
 	github.com/alecthomas/participle/v2 v2.1.4
 	github.com/bodgit/sevenzip v1.6.1
 	github.com/fatih/color v1.18.0
+	github.com/hashicorp/golang-lru/v2 v2.0.7
 	github.com/nwaples/rardecode v1.1.3
 	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/text v0.26.0
 )
 
 require (
 	github.com/andybalholm/brotli v1.1.1 // indirect
 	github.com/bodgit/plumbing v1.3.0 // indirect
 	github.com/bodgit/windows v1.0.1 // indirect
-	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
 	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
@@ -25,5 +26,4 @@ require (
 	github.com/spf13/afero v1.14.0 // indirect
 	go4.org v0.0.0-20230225012048-214862532bf5 // indirect
 	golang.org/x/sys v0.33.0 // indirect
-	golang.org/x/text v0.26.0 // indirect
 )